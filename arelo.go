@@ -2,16 +2,25 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"math/rand/v2"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"runtime/debug"
+	"runtime/pprof"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -24,54 +33,333 @@ import (
 )
 
 const (
-	waitForTerm = 5 * time.Second
+	confirmTimeout = 30 * time.Second // timeout of the --restart-confirm prompt
+
+	readyPoll = 100 * time.Millisecond // interval to check the --ready-file
+
+	crashLoopUptime = time.Minute // uptime after which a crash is not counted as a crash loop (--max-restarts)
+
+	eventRateWarnInterval = 10 * time.Second // minimum interval of the --max-event-rate warnings
+
+	textBusyRetries = 5                     // retries to start the command while the executable is busy
+	textBusyWait    = 50 * time.Millisecond // first wait before the retry, doubled on each retry
+
+	outputWaitDelay = time.Second // wait for the output pipes held by the children after the command exited
+
+	maxContentRead = 1024 * 1024 // bytes to read from the tail of the file to match the content
 )
 
 var (
 	version string
 	usage   = `Usage: arelo [OPTION]... -- COMMAND
+       arelo completion bash|zsh|fish
+       arelo init [--force]
 Run the COMMAND and restart when a file matches the pattern has been modified.
+Or write the shell completion script, or the starter config file.
 
 Options:
 `
-	targets  = pflag.StringArrayP("target", "t", nil, "observation target `path` (default \"./\")")
-	patterns = pflag.StringArrayP("pattern", "p", nil, "trigger pathname `glob` pattern (default \"**\")")
-	ignores  = pflag.StringArrayP("ignore", "i", nil, "ignore pathname `glob` pattern")
-	delay    = pflag.DurationP("delay", "d", time.Second, "`duration` to delay the restart of the command")
-	restart  = pflag.BoolP("restart", "r", false, "restart the command on exit")
-	sigopt   = pflag.StringP("signal", "s", "", "`signal` used to stop the command (default \"SIGTERM\")")
-	verbose  = pflag.BoolP("verbose", "v", false, "verbose output")
-	help     = pflag.BoolP("help", "h", false, "display this message")
-	showver  = pflag.BoolP("version", "V", false, "display version")
-	filters  = pflag.StringArrayP("filter", "f", nil, "filter file system `event` (CREATE|WRITE|REMOVE|RENAME|CHMOD)")
+	targets        = pflag.StringArrayP("target", "t", nil, "observation target `path` (default \"./\")")
+	patterns       = pflag.StringArrayP("pattern", "p", nil, "trigger pathname `glob` pattern (default \"**\")")
+	ignores        = pflag.StringArrayP("ignore", "i", nil, "ignore pathname `glob` pattern")
+	delay          = delayRangeP("delay", "d", time.Second, "`duration` to delay the restart of the command, or a range \"min-max\" to wait a random duration in it")
+	restart        = pflag.BoolP("restart-on-exit", "r", false, "restart the command on exit (--restart is also accepted)")
+	sigopt         = pflag.StringP("signal", "s", "", "`signal` used to stop the command (default \"SIGTERM\")")
+	verbose        = verboseLevelP("verbose", "v", "verbose output (-vv for more)")
+	help           = pflag.BoolP("help", "h", false, "display this message")
+	showver        = pflag.BoolP("version", "V", false, "display version")
+	filters        = pflag.StringArrayP("filter", "f", nil, "filter file system `event` (CREATE|WRITE|REMOVE|RENAME|CHMOD)")
+	dirchg         = pflag.Bool("restart-on-dir-change", false, "restart the command when a directory is created or removed")
+	delayFirst     = pflag.Bool("delay-first-only", false, "apply the delay only to the first triggered restart of a burst (ended by no trigger for the delay)")
+	burst          = pflag.String("burst-policy", "drop", "`policy` for the triggers during the delay (drop|debounce|queue)")
+	removeGrace    = pflag.Duration("remove-grace", 0, "`duration` to wait for the removed file to be created again")
+	explain        = pflag.Bool("explain", false, "explain why each file system event triggers the restart or not")
+	hupRestart     = pflag.Bool("hup-restarts", false, "restart the command on SIGHUP instead of exiting")
+	profile        = pflag.String("profile", "", "write CPU and memory profiles of arelo to `file`")
+	minUptime      = pflag.Duration("min-uptime", 0, "minimum `duration` the command runs before the triggered restart")
+	resolveLinks   = pflag.Bool("resolve-symlink-targets", false, "watch the real files of the symlink targets")
+	noExisting     = pflag.Bool("no-existing-triggers", false, "do not trigger by the existing files in a new directory")
+	runUser        = pflag.String("user", "", "run the command as the `user`")
+	runGroup       = pflag.String("group", "", "run the command as the `group`")
+	nice           = pflag.Int("nice", 0, "run the command with the nice value `N`")
+	affinity       = pflag.String("cpu-affinity", "", "pin the command to the `cpus` (e.g. \"0,1\", \"0-3\")")
+	drySignal      = pflag.Bool("dry-signal", false, "restart the command on SIGUSR1 to test the kill path")
+	keepAlive      = pflag.Bool("keep-alive", false, "restart the command when it exits unexpectedly")
+	strictTargets  = pflag.Bool("strict-target-patterns", false, "apply the patterns only to the preceding target")
+	stdinFile      = pflag.String("stdin-file", "", "feed the `file` to the stdin of the command instead of the stdin of arelo")
+	reloadCmd      = pflag.String("reload-command", "", "run the `command` by the shell on each trigger instead of restarting the COMMAND")
+	watchMode      = pflag.String("watch-mode", "fsnotify", "`mode` to detect the modifications (fsnotify|fanotify|git-status|none)")
+	gitInterval    = pflag.Duration("git-interval", time.Second, "`duration` between the polls of git status on git-status mode")
+	cmdopt         = pflag.String("cmd", "", "`command` line to run, split like the shell (overridden by the COMMAND after \"--\")")
+	contentOpts    = pflag.StringArray("restart-on-file-content-match", nil, "trigger only when the content of the file matched to the glob matches the regexp (`glob=regexp`)")
+	sizeOpts       = pflag.StringArray("size-trigger", nil, "trigger only when the size of the file matched to the glob crosses the threshold (`glob:[<|<=|>|>=]size`)")
+	maxWatches     = pflag.Int("max-watches", 0, "stop watching new directories when `N` directories are watched (0: unlimited)")
+	symlinkCreate  = pflag.Bool("trigger-on-symlink-create", false, "trigger by the creation of the dangling symlinks too")
+	ignoreOlder    = pflag.Duration("ignore-older-than", 0, "ignore the events of the files modified more than `duration` ago")
+	ignoreNewer    = pflag.Duration("ignore-newer-than", 0, "ignore the events of the files modified less than `duration` ago")
+	noRestartCodes = pflag.IntSlice("no-restart-on-code", nil, "exit `codes` of the command not to restart by --restart-on-exit and --keep-alive")
+	watchStdin     = pflag.Bool("watch-from-stdin", false, "read the commands (restart [file], reload, quit) from stdin instead of forwarding it")
+	hookURL        = pflag.String("restart-hook-url", "", "post the JSON of each restart and exit of the command to the `url`")
+	hookWatchSet   = pflag.Bool("hook-watch-set", false, "post the paths added to or removed from the watch set at runtime to the --restart-hook-url too")
+	onDemand       = pflag.Bool("restart-on-change-only-if-running", false, "do not start the command until the first change (on-demand start)")
+	interval       = pflag.Duration("interval", 0, "run the command every `duration` in addition to the modifications")
+	summaryOnExit  = pflag.Bool("summary-on-exit", false, "print the summary of the session on exit")
+	newSession     = pflag.Bool("new-session", false, "run the command in a new session (setsid) detached from the terminal")
+	logJSONFile    = pflag.String("log-json-file", "", "write the logs of arelo to the `file` as JSON lines too")
+	logMaxSize     = pflag.String("log-max-size", "10MB", "rotate the --log-json-file when it exceeds the `size`")
+	logMaxFiles    = pflag.Int("log-max-files", 3, "number of the rotated --log-json-file to retain")
+	outputFile     = pflag.String("output-file", "", "write the stdout and stderr of the command to the `file` too")
+	stderrFile     = pflag.String("stderr-file", "", "write the stderr of the command to the `file` instead of the --output-file")
+	outputAppend   = pflag.Bool("output-append", false, "append to the --output-file and --stderr-file instead of truncating on each run")
+	confirm        = pflag.Bool("restart-confirm", false, "ask on the terminal before each triggered restart (no restart on EOF or timeout)")
+	readyFile      = pflag.String("ready-file", "", "wait for the `file` to be created or updated after each start before accepting the next trigger")
+	readyTimeout   = pflag.Duration("ready-timeout", 30*time.Second, "`duration` to wait for the --ready-file (0: no timeout)")
+	interpreter    = pflag.String("interpreter", "", "run the COMMAND (joined by spaces) and --reload-command as a string by the `interpreter` (e.g. \"bash -c\")")
+	forceStdin     = pflag.Bool("stdin", false, "forward the stdin to the command even if it is not a terminal")
+	noStdinOpt     = pflag.Bool("no-stdin", false, "do not forward the stdin to the command")
+	maxEventRate   = pflag.Int("max-event-rate", 1000, "warn when more than `N` file system events come in a second (0: never)")
+	eventSummary   = pflag.Duration("watch-changes-summary-interval", 0, "log the summary of the file system events every `duration` instead of each event in verbose mode (-vv logs each event)")
+	envs           = pflag.StringArray("env", nil, "set the environment variable of the command (`KEY=VALUE`)")
+	cleanEnv       = pflag.Bool("clean-env", false, "run the command with only PATH, HOME and the --env variables")
+	onChmod        = pflag.String("on-chmod", "any", "`mode` of the CHMOD events to trigger (any|exec), exec triggers only when the executable bits change")
+	smart          = pflag.Bool("smart", false, "apply the presets for the saves by editors (ignore temporary files, filter CHMOD, debounce)")
+	maxRestarts    = pflag.Int("max-restarts", 0, "pause the auto restart by --restart-on-exit and --keep-alive after `N` crashes in a row until the next trigger (0: unlimited)")
+	cooldownOpt    = pflag.Duration("file-cooldown", 0, "minimum `duration` between the triggers by the same file")
+	debounceLow    = pflag.StringArray("debounce-low-ops", []string{"CHMOD"}, "low priority file system `event` not extending the delay on --burst-policy=debounce nor the quiet period of --debounce")
+	onAccess       = pflag.Bool("on-access", false, "trigger by the read access to the files too (fanotify mode only)")
+	minChanged     = pflag.Int("min-changed-files", 0, "restart only after `N` distinct files changed or the --min-changed-window elapsed")
+	minWindow      = pflag.Duration("min-changed-window", 10*time.Second, "`duration` from the first change to restart with less than --min-changed-files (0: no timeout)")
+	statusSig      = pflag.String("status-signal", "", "print the status of arelo (e.g. why not restarting) on the `signal` (e.g. SIGUSR2)")
+	targetList     = pflag.StringArray("targets", nil, "comma separated observation target `paths`")
+	patternList    = pflag.StringArray("patterns", nil, "comma separated trigger pathname `globs`")
+	listSep        = pflag.String("list-separator", ",", "`separator` of the lists of --targets and --patterns")
+	printTree      = pflag.Bool("print-watched-tree", false, "print the directories and files to be watched as a tree and exit")
+	watchAlsoOpt   = pflag.StringArray("watch-also", nil, "watch the `file` too, regardless of the targets, the patterns and the ignores")
+	sigTimeout     = pflag.Duration("kill-timeout-per-signal", 5*time.Second, "`duration` to wait for the command to stop by the --signal before sending SIGKILL")
+	killTimeout    = pflag.Duration("kill-timeout", 5*time.Second, "`duration` to wait for the command to stop by SIGKILL before giving up (0: forever)")
+	noChange       = pflag.Bool("no-restart-on-change", false, "do not restart the command on the file changes, only on exit, the signals, stdin or --interval")
+	noExpandCmd    = pflag.Bool("no-expand-env", false, "do not expand the environment variables in the COMMAND (e.g. for a script given to the shell)")
+	configFile     = pflag.String("config", "", "read the options from the config `file` (default \".arelo.yaml\" if exists)")
+	debounceOpt    = pflag.Duration("debounce", 0, "coalesce the events of the same file until it is quiet for the `duration` (0: no coalescing)")
+	jitterSeedOpt  = pflag.Uint64("jitter-seed", 0, "`seed` of the random duration of the --delay range, for the reproducible runs (default: seeded by the time)")
+	watchTargetPar = pflag.Bool("watch-target-parents", false, "watch the parents of the targets to follow the targets renamed, removed or replaced")
 )
 
 func main() {
-	pflag.Parse()
+	begin := time.Now()
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		if err := completion(os.Stdout, os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		if err := initConfig(os.Stdout, ".", os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	pflag.CommandLine.SetNormalizeFunc(oldFlagNames)
+	pflag.ParseAll(func(f *pflag.Flag, value string) error {
+		optArgs = append(optArgs, optArg{f.Name, value})
+		return pflag.Set(f.Name, value)
+	})
 	if *showver {
 		fmt.Println("arelo version", versionstr())
 		return
 	}
+	if *listSep == "" {
+		fmt.Fprintf(os.Stderr, "%s: --list-separator must not be empty\n", os.Args[0])
+		os.Exit(1)
+	}
+	if envArgs, err := applyEnvOptions(pflag.CommandLine, os.Getenv, *listSep); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	} else {
+		optArgs = append(envArgs, optArgs...)
+	}
+	cfgFile, optional := *configFile, false
+	if cfgFile == "" {
+		cfgFile, optional = defaultConfigFile, true
+	}
+	if cfg, err := readConfigFile(cfgFile, optional); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	} else if cfg == nil {
+		cfgFile = "" // no default config file
+	} else {
+		// the command line and the environment variables override the config file.
+		cfgArgs, err := applyConfig(pflag.CommandLine, cfg, func(name string) bool {
+			return pflag.CommandLine.Changed(name) || name == "cmd" && pflag.NArg() > 0
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: config %q: %v\n", os.Args[0], cfgFile, err)
+			os.Exit(1)
+		}
+		optArgs = append(cfgArgs, optArgs...)
+	}
 	cmd := pflag.Args()
+	if *interpreter != "" {
+		str := *cmdopt
+		if len(cmd) > 0 {
+			str = strings.Join(cmd, " ")
+		}
+		if c, err := interpreterCommand(*interpreter, str); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			os.Exit(1)
+		} else if str != "" {
+			cmd = c
+		}
+	} else if len(cmd) == 0 && *cmdopt != "" {
+		c, err := splitCommand(*cmdopt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: --cmd: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		cmd = c
+	}
+	for _, l := range *targetList {
+		*targets = append(*targets, splitList(l, *listSep)...)
+	}
+	for _, l := range *patternList {
+		*patterns = append(*patterns, splitList(l, *listSep)...)
+	}
+	optArgs = expandListArgs(optArgs, *listSep)
+	// the variables are expanded by arelo for the environments without the shell (e.g. make, task runners).
+	expandCommand(cmd, !*noExpandCmd)
+	expandEnvAll(*targets)
+	expandEnvAll(*patterns)
+	expandEnvAll(*ignores)
+	for i, a := range optArgs {
+		switch a.name {
+		case "target", "pattern", "ignore":
+			optArgs[i].value = expandEnv(a.value)
+		}
+	}
+	if *smart {
+		applySmart(pflag.CommandLine.Changed)
+	}
 	if *targets == nil {
 		*targets = []string{"./"}
 	}
 	if *patterns == nil {
 		*patterns = []string{"**"}
 	}
+	if crs, err := parseContentRules(*contentOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	} else {
+		contentRules = crs
+	}
+	if srs, err := parseSizeRules(*sizeOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	} else {
+		sizeRules = srs
+	}
+	if err := validatePatterns("pattern (-p)", *patterns); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+	if err := validatePatterns("ignore (-i)", *ignores); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+	if *strictTargets {
+		targetPats = targetPatterns(optArgs)
+	}
+	if *logJSONFile != "" {
+		size, err := parseByteSize(*logMaxSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: --log-max-size: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		f, err := openRotateFile(*logJSONFile, size, *logMaxFiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		log.SetOutput(io.MultiWriter(os.Stderr, jsonLogWriter{f}))
+	}
 	sig, sigstr := parseSignalOption(*sigopt)
 	filtOp, err := parseFilters(*filters)
 	if err != nil {
 		log.Fatalf("[ARELO] %v", err)
 	}
+	lowOps, err := parseFilters(slices.DeleteFunc(*debounceLow, func(s string) bool { return s == "" }))
+	if err != nil {
+		log.Fatalf("[ARELO] --debounce-low-ops: %v", err)
+	}
+	debounceLowOps = lowOps
 	logVerbose("command:  %q", cmd)
+	logVerbose("config:   %q", cfgFile)
+	logVerbose("expand:   %v", !*noExpandCmd)
+	logVerbose("smart:    %v", *smart)
+	logVerbose("interp:   %q", *interpreter)
 	logVerbose("targets:  %q", *targets)
 	logVerbose("patterns: %q", *patterns)
+	if targetPats != nil {
+		for _, t := range *targets {
+			t = path.Clean(t)
+			logVerbose("patterns of %q: %q", t, targetPats[t])
+		}
+	}
 	logVerbose("ignores:  %q", *ignores)
-	logVerbose("filter:   %v", filtOp)
+	logVerbose("also:     %q", *watchAlsoOpt)
+	logVerbose("parents:  %v", *watchTargetPar)
+	logVerbose("mtime:    older=%v newer=%v", *ignoreOlder, *ignoreNewer)
+	logVerbose("contents: %q", *contentOpts)
+	logVerbose("sizes:    %q", *sizeOpts)
+	logVerbose("filter:   %v", opString(filtOp))
+	logVerbose("onchmod:  %s", *onChmod)
+	logVerbose("cooldown: %v", *cooldownOpt)
+	logVerbose("mode:     %s (access: %v)", *watchMode, *onAccess)
 	logVerbose("delay:    %v", delay)
-	logVerbose("signal:   %s", sigstr)
-	logVerbose("restart:  %v", *restart)
+	if pflag.CommandLine.Changed("jitter-seed") {
+		jitterSeed = *jitterSeedOpt
+	}
+	logVerbose("seed:     %v", jitterSeed)
+	logVerbose("debounce: %v", *debounceOpt)
+	logVerbose("interval: %v", *interval)
+	logVerbose("signal:   %s (timeout %v, kill timeout %v)", sigstr, *sigTimeout, *killTimeout)
+	logVerbose("restart:  on exit: %v, on change: %v", *restart, !*noChange)
+	logVerbose("keepalive: %v", *keepAlive)
+	logVerbose("maxrestarts: %v", *maxRestarts)
+	logVerbose("ondemand: %v", *onDemand)
+	logVerbose("ready:    %q (timeout %v)", *readyFile, *readyTimeout)
+	logVerbose("norestart: %v", *noRestartCodes)
+	logVerbose("nice:     %v", *nice)
+	logVerbose("affinity: %q", *affinity)
+	logVerbose("session:  %v", *newSession)
+	logVerbose("env:      %q clean=%v", *envs, *cleanEnv)
+	logVerbose("user:     %q", *runUser)
+	logVerbose("group:    %q", *runGroup)
+	logVerbose("burst:    %s (low ops: %v)", *burst, opString(debounceLowOps))
+	logVerbose("delay1st: %v", *delayFirst)
+	logVerbose("minchanged: %v (window %v)", *minChanged, *minWindow)
+	logVerbose("uptime:   %v", *minUptime)
+	logVerbose("dirchg:   %v", *dirchg)
+	logVerbose("existing: %v", !*noExisting)
+	logVerbose("maxwatch: %v", *maxWatches)
+	logVerbose("maxrate:  %v", *maxEventRate)
+	logVerbose("evsummary: %v", *eventSummary)
+	logVerbose("symlinks: %v", *resolveLinks)
+	logVerbose("dangling: %v", *symlinkCreate)
+	logVerbose("hup:      %v", *hupRestart)
+	logVerbose("drysig:   %v", *drySignal)
+	logVerbose("statussig: %q", *statusSig)
+	logVerbose("summary:  %v", *summaryOnExit)
+	logVerbose("stdin:    %q", *stdinFile)
+	logVerbose("stdincmd: %v", *watchStdin)
+	logVerbose("forward:  stdin=%v nostdin=%v terminal=%v", *forceStdin, *noStdinOpt, isTerminal(os.Stdin))
+	logVerbose("confirm:  %v", *confirm)
+	logVerbose("output:   %q stderr=%q append=%v", *outputFile, *stderrFile, *outputAppend)
+	logVerbose("reload:   %q", *reloadCmd)
+	logVerbose("hook:     %q (watch set: %v)", *hookURL, *hookWatchSet)
+	logVerbose("logfile:  %q (max %s, %d files)", *logJSONFile, *logMaxSize, *logMaxFiles)
 
 	if *help {
 		fmt.Println("arelo version", versionstr())
@@ -80,7 +368,15 @@ func main() {
 		return
 	}
 
-	if len(cmd) == 0 {
+	if *printTree {
+		if err := printWatchedTree(os.Stdout, *targets, *patterns, *ignores, *maxWatches); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(cmd) == 0 && *reloadCmd == "" {
 		fmt.Fprintf(os.Stderr, "%s: COMMAND required.\n", os.Args[0])
 		os.Exit(1)
 	}
@@ -88,52 +384,213 @@ func main() {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], sigstr)
 		os.Exit(1)
 	}
+	if env, err := commandEnv(os.Environ(), *cleanEnv, *envs); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	} else {
+		cmdEnv = env
+	}
+	delaySpread = delay.max - delay.min
+	if err := setupCredential(*runUser, *runGroup); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+	if *drySignal && dryRunSignal == nil {
+		fmt.Fprintf(os.Stderr, "%s: Dry signal option (--dry-signal) is not available on Windows.\n", os.Args[0])
+		os.Exit(1)
+	}
+	var statusSignal os.Signal
+	if *statusSig != "" {
+		if dryRunSignal == nil {
+			fmt.Fprintf(os.Stderr, "%s: Status signal option (--status-signal) is not available on Windows.\n", os.Args[0])
+			os.Exit(1)
+		}
+		ss, str := parseSignalOption(*statusSig)
+		switch {
+		case ss == nil:
+			fmt.Fprintf(os.Stderr, "%s: --status-signal: %s\n", os.Args[0], str)
+			os.Exit(1)
+		case ss == syscall.SIGINT || ss == syscall.SIGTERM || ss == syscall.SIGKILL:
+			fmt.Fprintf(os.Stderr, "%s: --status-signal cannot be %s\n", os.Args[0], str)
+			os.Exit(1)
+		case *drySignal && ss == dryRunSignal:
+			fmt.Fprintf(os.Stderr, "%s: --status-signal %s conflicts with --dry-signal\n", os.Args[0], str)
+			os.Exit(1)
+		case *hupRestart && ss == syscall.SIGHUP:
+			fmt.Fprintf(os.Stderr, "%s: --status-signal %s conflicts with --hup-restarts\n", os.Args[0], str)
+			os.Exit(1)
+		}
+		statusSignal = ss
+	}
+	if *newSession && !newSessionAvailable {
+		fmt.Fprintf(os.Stderr, "%s: New session option (--new-session) is not available on Windows.\n", os.Args[0])
+		os.Exit(1)
+	}
+	if err := setupCPUAffinity(*affinity); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+	if *forceStdin && *noStdinOpt {
+		fmt.Fprintf(os.Stderr, "%s: --stdin and --no-stdin are exclusive.\n", os.Args[0])
+		os.Exit(1)
+	}
+	if *watchStdin && *confirm {
+		fmt.Fprintf(os.Stderr, "%s: --watch-from-stdin and --restart-confirm are exclusive.\n", os.Args[0])
+		os.Exit(1)
+	}
+	if *watchStdin && *stdinFile != "" {
+		fmt.Fprintf(os.Stderr, "%s: --watch-from-stdin and --stdin-file are exclusive.\n", os.Args[0])
+		os.Exit(1)
+	}
+	if *stdinFile != "" {
+		if _, err := os.Stat(*stdinFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: stdin file: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+	}
+	switch *watchMode {
+	case "fsnotify", "fanotify", "git-status", "none":
+	default:
+		fmt.Fprintf(os.Stderr, "%s: invalid watch mode: %s\n", os.Args[0], *watchMode)
+		os.Exit(1)
+	}
+	if len(*watchAlsoOpt) > 0 && (*watchMode == "git-status" || *watchMode == "none") {
+		fmt.Fprintf(os.Stderr, "%s: --watch-also is not available in %s mode\n", os.Args[0], *watchMode)
+		os.Exit(1)
+	}
+	if *hookWatchSet && *hookURL == "" {
+		fmt.Fprintf(os.Stderr, "%s: --hook-watch-set requires --restart-hook-url\n", os.Args[0])
+		os.Exit(1)
+	}
+	if *onAccess && *watchMode != "fanotify" {
+		fmt.Fprintf(os.Stderr, "%s: --on-access requires --watch-mode=fanotify\n", os.Args[0])
+		os.Exit(1)
+	}
+	switch *onChmod {
+	case "any", "exec":
+	default:
+		fmt.Fprintf(os.Stderr, "%s: invalid on-chmod mode: %s\n", os.Args[0], *onChmod)
+		os.Exit(1)
+	}
+	if *onChmod == "exec" && runtime.GOOS == "windows" {
+		// the executable bits never change on Windows, so every pure CHMOD would be ignored.
+		fmt.Fprintf(os.Stderr, "%s: --on-chmod=exec is not available on Windows\n", os.Args[0])
+		os.Exit(1)
+	}
+	switch *burst {
+	case "drop", "debounce", "queue":
+	default:
+		fmt.Fprintf(os.Stderr, "%s: invalid burst policy: %s\n", os.Args[0], *burst)
+		os.Exit(1)
+	}
+
+	if *profile != "" {
+		stop, err := startProfile(*profile)
+		if err != nil {
+			log.Fatalf("[ARELO] profile error: %v", err)
+		}
+		defer stop()
+	}
 
-	modC, errC, err := watcher(*targets, *patterns, *ignores, filtOp)
+	ctx, cancel := context.WithCancel(context.Background())
+	var modC <-chan trigger
+	var errC <-chan error
+	switch {
+	case *noChange || *watchMode == "none":
+		// triggered only by the signals or stdin.
+	case *watchMode == "git-status":
+		modC, errC, err = gitWatcher(ctx, ".", *targets, *patterns, *ignores, *gitInterval)
+	default:
+		modC, errC, err = watcher(*targets, *patterns, *ignores, filtOp)
+	}
 	if err != nil {
 		log.Fatalf("[ARELO] wacher error: %v", err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
-	reload := runner(ctx, &wg, cmd, *delay, sig.(syscall.Signal), *restart)
+	var reload, restartC chan<- trigger
+	if len(cmd) > 0 {
+		restartC = runner(ctx, &wg, cmd, delay.min, sig.(syscall.Signal), *restart)
+		reload = restartC
+	}
+	if *reloadCmd != "" {
+		// the COMMAND keeps running, it is not restarted by the triggers.
+		reload = reloader(ctx, &wg, *reloadCmd, delay.min, sig.(syscall.Signal))
+	}
 
+	// all the trigger sources push onto reload (or restartC),
+	// so that the runner (or the reloader) collects them in the same way (collectTriggers, waitDelay):
+	// --min-changed-files, --burst-policy, --debounce-low-ops and the delay are applied to all of them.
+	// - the watcher
+	// - the scheduler (--interval)
+	// - stdin (--watch-from-stdin)
+	// - the signals (--hup-restarts, --dry-signal)
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case name, ok := <-modC:
-				if !ok {
-					cancel()
-					wg.Wait()
-					log.Fatalf("[ARELO] wacher closed")
-					return
-				}
-				reload <- name
-			case err := <-errC:
-				cancel()
-				wg.Wait()
-				log.Fatalf("[ARELO] wacher error: %v", err)
-				return
-			}
+		if err := forwardTriggers(ctx, modC, errC, reload); err != nil {
+			cancel()
+			wg.Wait()
+			log.Fatalf("[ARELO] %v", err)
 		}
 	}()
+	if *interval > 0 {
+		go scheduler(ctx, *interval, reload)
+	}
+	quit := make(chan struct{})
+	if *watchStdin {
+		go stdinCommands(os.Stdin, restartC, reload, quit)
+	}
 
 	s := make(chan os.Signal, 1)
 	signal.Notify(s, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
-	sig = <-s
-	log.Printf("[ARELO] signal: %v", sig)
+	if *drySignal {
+		signal.Notify(s, dryRunSignal)
+	}
+	if statusSignal != nil {
+		signal.Notify(s, statusSignal)
+	}
+loop:
+	for {
+		select {
+		case <-quit:
+			log.Printf("[ARELO] quit by stdin")
+			break loop
+		case sig = <-s:
+		}
+		log.Printf("[ARELO] signal: %v", sig)
+		if statusSignal != nil && sig == statusSignal {
+			session.printStatus(time.Now())
+			continue
+		}
+		if *hupRestart && sig == syscall.SIGHUP {
+			reload <- trigger{name: "SIGHUP"}
+			continue
+		}
+		if *drySignal && sig == dryRunSignal {
+			log.Printf("[ARELO] dry signal: restart the command to test the kill path")
+			reload <- trigger{name: "SIGUSR1"}
+			continue
+		}
+		break
+	}
 	cancel()
 	wg.Wait()
+	if *summaryOnExit {
+		session.print(time.Since(begin))
+	}
 }
 
 func logVerbose(fmt string, args ...interface{}) {
-	if *verbose {
+	if *verbose > 0 {
 		log.Printf("[ARELO] "+fmt, args...)
 	}
 }
 
+func logExplain(explain bool, fmt string, args ...interface{}) {
+	if explain {
+		log.Printf("[ARELO] explain: "+fmt, args...)
+	}
+}
+
 func versionstr() string {
 	if version != "" {
 		return "v" + version
@@ -145,6 +602,35 @@ func versionstr() string {
 	return info.Main.Version
 }
 
+// startProfile starts CPU profiling into the file.
+// The returned function stops it and writes the heap profile into "file.heap".
+func startProfile(file string) (func(), error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, xerrors.Errorf("create: %w", err)
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("start cpu profile: %w", err)
+	}
+	logVerbose("profiling: %q", file)
+
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+
+		h, err := os.Create(file + ".heap")
+		if err != nil {
+			log.Printf("[ARELO] profile error: %v", err)
+			return
+		}
+		defer h.Close()
+		if err := pprof.WriteHeapProfile(h); err != nil {
+			log.Printf("[ARELO] profile error: %v", err)
+		}
+	}, nil
+}
+
 func parseFilters(filters []string) (fsnotify.Op, error) {
 	var op fsnotify.Op
 	for _, f := range filters {
@@ -159,6 +645,8 @@ func parseFilters(filters []string) (fsnotify.Op, error) {
 			op |= fsnotify.Rename
 		case "CHMOD":
 			op |= fsnotify.Chmod
+		case "ACCESS":
+			op |= opAccess
 		default:
 			return 0, xerrors.Errorf("invalid filter event: %s", f)
 		}
@@ -166,146 +654,1593 @@ func parseFilters(filters []string) (fsnotify.Op, error) {
 	return op, nil
 }
 
-func watcher(targets, patterns, ignores []string, filtOp fsnotify.Op) (<-chan string, <-chan error, error) {
-	w, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, nil, err
-	}
+// opAccess is the read access to the file, which is reported only by fanotify with --on-access.
+const opAccess fsnotify.Op = 1 << 16
 
-	if err := addTargets(w, targets, patterns, ignores); err != nil {
-		return nil, nil, err
+// opString returns the string of op including opAccess unknown to fsnotify.
+func opString(op fsnotify.Op) string {
+	if !op.Has(opAccess) {
+		return op.String()
 	}
+	if op == opAccess {
+		return "ACCESS"
+	}
+	return (op &^ opAccess).String() + "|ACCESS"
+}
 
-	modC := make(chan string)
-	errC := make(chan error)
-	watchOp := ^filtOp
-
-	go func() {
-		defer close(modC)
-		for {
-			select {
-			case event, ok := <-w.Events:
-				if !ok {
-					errC <- xerrors.Errorf("watcher.Events closed")
-					return
-				}
-
-				name := filepath.ToSlash(event.Name)
-				logVerbose("event: %v %q", event.Op, name)
+// isWatchedOp reports whether op has any operation which is not filtered.
+//
+// Some systems deliver multiple operations in an event at once (e.g. WRITE|CHMOD).
+// Such an event is watched unless all of the operations are filtered,
+// so that filtering CHMOD does not drop the write.
+func isWatchedOp(op, filtOp fsnotify.Op) bool {
+	return op&^filtOp != 0
+}
 
-				if ignore, err := matchPatterns(name, ignores); err != nil {
-					errC <- xerrors.Errorf("match ignores: %w", err)
-					return
-				} else if ignore {
-					continue
-				}
+// splitCommand splits the command line into the arguments like the shell.
+// The words are separated by the spaces, and can be quoted by ' or ", or escaped by \.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped {
+		return nil, xerrors.New("trailing backslash")
+	}
+	if quote != 0 {
+		return nil, xerrors.Errorf("unterminated quote: %c", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
 
-				if event.Has(watchOp) {
-					if match, err := matchPatterns(name, patterns); err != nil {
-						errC <- xerrors.Errorf("match patterns: %w", err)
-						return
-					} else if match {
-						modC <- name
-					}
-				}
+// cmdEnv is the environment of the command, set by commandEnv in main (nil to inherit).
+var cmdEnv []string
 
-				// add watcher if new directory.
-				if event.Has(fsnotify.Create) {
-					fi, err := os.Stat(name)
-					if err != nil {
-						// ignore stat errors (notfound, permission, etc.)
-						log.Printf("[ARELO] watcher: %v", err)
-					} else if fi.IsDir() {
-						err := addDirRecursive(w, fi, name, patterns, ignores, modC)
-						if err != nil {
-							errC <- err
-							return
-						}
-					}
+// commandEnv returns the environment of the command from the environ of arelo and the --env entries.
+// When clean is true, only the variables in baseEnv are taken from the environ.
+// It returns nil when the environ is inherited as is.
+func commandEnv(environ []string, clean bool, envs []string) ([]string, error) {
+	for _, e := range envs {
+		if k, _, ok := strings.Cut(e, "="); !ok || k == "" {
+			return nil, xerrors.Errorf("invalid env (KEY=VALUE): %s", e)
+		}
+	}
+	if !clean && len(envs) == 0 {
+		return nil, nil
+	}
+	env := []string{}
+	if !clean {
+		env = append(env, environ...)
+	} else {
+		for _, e := range environ {
+			k, _, _ := strings.Cut(e, "=")
+			for _, b := range baseEnv {
+				if strings.EqualFold(k, b) {
+					env = append(env, e)
 				}
-
-			case err, ok := <-w.Errors:
-				errC <- xerrors.Errorf("watcher.Errors (%v): %w", ok, err)
-				return
 			}
 		}
-	}()
+	}
+	// the later one takes precedence in exec.Cmd.
+	return append(env, envs...), nil
+}
 
-	return modC, errC, nil
+// interpreterCommand returns the command line to run the str by the interpreter (--interpreter).
+// The interpreter is split like the shell, and the str is appended as the last argument.
+func interpreterCommand(interp, str string) ([]string, error) {
+	args, err := splitCommand(interp)
+	if err != nil {
+		return nil, xerrors.Errorf("interpreter: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, xerrors.New("interpreter: empty")
+	}
+	return append(args, str), nil
 }
 
-func matchPatterns(t string, pats []string) (bool, error) {
-	for _, p := range pats {
-		m, err := doublestar.Match(p, t)
-		if err != nil {
-			return false, xerrors.Errorf("match(%v, %v): %w", p, t, err)
+// contentRule is the regexp which the content of the file matched to the glob must match.
+type contentRule struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// contentRules is the rules given by --restart-on-file-content-match.
+var contentRules []contentRule
+
+// parseContentRules parses the rules formatted as "glob=regexp".
+func parseContentRules(rules []string) ([]contentRule, error) {
+	var crs []contentRule
+	for _, r := range rules {
+		glob, expr, ok := strings.Cut(r, "=")
+		if !ok || glob == "" {
+			return nil, xerrors.Errorf("invalid content match (glob=regexp): %s", r)
 		}
-		if m {
-			return true, nil
+		if !doublestar.ValidatePattern(glob) {
+			return nil, xerrors.Errorf("invalid content match glob: %s", glob)
 		}
-		if strings.HasPrefix(t, "./") {
-			m, err = doublestar.Match(p, t[2:])
-			if err != nil {
-				return false, xerrors.Errorf("match(%v, %v): %w", p, t[2:], err)
-			}
-			if m {
-				return true, nil
-			}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid content match regexp: %w", err)
 		}
+		crs = append(crs, contentRule{glob, re})
 	}
-	return false, nil
+	return crs, nil
 }
 
-func addTargets(w *fsnotify.Watcher, targets, patterns, ignores []string) error {
-	for _, t := range targets {
-		t = path.Clean(t)
-		fi, err := os.Stat(t)
-		if err != nil {
-			return xerrors.Errorf("stat: %w", err)
-		}
-		if fi.IsDir() {
-			if err := addDirRecursive(w, fi, t, patterns, ignores, nil); err != nil {
-				return err
-			}
+// matchContent reports whether the file satisfies the content rules.
+//
+// The file matched to no rule is always satisfied.
+// Otherwise, the file is satisfied only when it is written or created and
+// the tail of the content (up to maxContentRead bytes) matches any of the regexps of the matched rules.
+// It returns the matched rules as the reason.
+func matchContent(name string, op fsnotify.Op, rules []contentRule) (bool, []string, error) {
+	var res []*regexp.Regexp
+	var globs []string
+	for _, r := range rules {
+		if m, err := matchPatterns(name, []string{r.glob}); err != nil {
+			return false, nil, err
+		} else if m {
+			res = append(res, r.re)
+			globs = append(globs, r.glob+"="+r.re.String())
 		}
-		logVerbose("watching target: %q", t)
-		if err := w.Add(t); err != nil {
-			return err
+	}
+	if res == nil {
+		return true, nil, nil
+	}
+	if !op.Has(fsnotify.Write) && !op.Has(fsnotify.Create) {
+		return false, globs, nil
+	}
+
+	b, err := readTail(name, maxContentRead)
+	if err != nil {
+		// the file may be removed already.
+		logVerbose("read content: %v", err)
+		return false, globs, nil
+	}
+	for i, re := range res {
+		if re.Match(b) {
+			return true, globs[i : i+1], nil
 		}
 	}
-	return nil
+	return false, globs, nil
 }
 
-func addDirRecursive(w *fsnotify.Watcher, fi fs.FileInfo, t string, patterns, ignores []string, ch chan<- string) error {
-	logVerbose("watching target: %q", t)
-	err := w.Add(t)
+// readTail reads the last n bytes of the file at most.
+func readTail(name string, n int64) ([]byte, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		return xerrors.Errorf("wacher add: %w", err)
+		return nil, err
 	}
-	des, err := os.ReadDir(t)
+	defer f.Close()
+	fi, err := f.Stat()
 	if err != nil {
-		return xerrors.Errorf("read dir: %w", err)
+		return nil, err
 	}
-	for _, de := range des {
-		name := path.Join(t, de.Name())
-		if ignore, err := matchPatterns(name, ignores); err != nil {
-			return xerrors.Errorf("match ignores: %w", err)
-		} else if ignore {
-			continue
-		}
-		if ch != nil {
-			if match, err := matchPatterns(name, patterns); err != nil {
-				return xerrors.Errorf("match patterns: %w", err)
-			} else if match {
-				ch <- name
-			}
+	if fi.IsDir() {
+		return nil, xerrors.Errorf("%s is a directory", name)
+	}
+	if off := fi.Size() - n; off > 0 {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			return nil, err
 		}
-		if de.IsDir() {
-			fi, err := de.Info()
-			if err != nil {
-				return err
+	}
+	return io.ReadAll(io.LimitReader(f, n))
+}
+
+// sizeRule is the threshold of the size of the file matched to the glob.
+type sizeRule struct {
+	glob string
+	op   string // "<", "<=", ">" or ">="
+	size int64
+}
+
+func (r sizeRule) String() string {
+	return fmt.Sprintf("%s:%s%d", r.glob, r.op, r.size)
+}
+
+// satisfied reports whether the size satisfies the threshold.
+func (r sizeRule) satisfied(size int64) bool {
+	switch r.op {
+	case "<":
+		return size < r.size
+	case "<=":
+		return size <= r.size
+	case ">":
+		return size > r.size
+	}
+	return size >= r.size
+}
+
+// sizeRules is the rules given by --size-trigger.
+var sizeRules []sizeRule
+
+var sizeRuleRe = regexp.MustCompile(`^(<=|>=|<|>)\s*([0-9]+)\s*([A-Za-z]*)$`)
+
+var sizeUnits = map[string]int64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+}
+
+// parseSizeRules parses the rules formatted as "glob:>1MB".
+func parseSizeRules(rules []string) ([]sizeRule, error) {
+	var srs []sizeRule
+	for _, r := range rules {
+		i := strings.LastIndex(r, ":")
+		if i <= 0 {
+			return nil, xerrors.Errorf("invalid size trigger (glob:[<|<=|>|>=]size): %s", r)
+		}
+		glob := r[:i]
+		if !doublestar.ValidatePattern(glob) {
+			return nil, xerrors.Errorf("invalid size trigger glob: %s", glob)
+		}
+		m := sizeRuleRe.FindStringSubmatch(strings.TrimSpace(r[i+1:]))
+		if m == nil {
+			return nil, xerrors.Errorf("invalid size trigger threshold: %s", r[i+1:])
+		}
+		unit, ok := sizeUnits[strings.ToLower(m[3])]
+		if !ok {
+			return nil, xerrors.Errorf("invalid size trigger unit: %s", m[3])
+		}
+		n, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid size trigger size: %w", err)
+		}
+		srs = append(srs, sizeRule{glob, m[1], n * unit})
+	}
+	return srs, nil
+}
+
+// initSizes records the sizes of the existing files in the targets matched to the size rules.
+func initSizes(targets []string, rules []sizeRule, sizes map[string]int64) {
+	if rules == nil {
+		return
+	}
+	for _, t := range targets {
+		filepath.WalkDir(t, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			p = filepath.ToSlash(p)
+			for _, r := range rules {
+				if m, _ := matchPatterns(p, []string{r.glob}); m {
+					if fi, err := d.Info(); err == nil {
+						sizes[p] = fi.Size()
+					}
+					break
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// matchSize reports whether the size of the file crosses any threshold of the size rules.
+//
+// The file matched to no rule is always satisfied.
+// Otherwise, the file is satisfied only when it is written or created and the size
+// changes from unsatisfied to satisfied for any of the matched rules.
+// The previous sizes are recorded in sizes. A new file is regarded as it was empty.
+// It returns the matched rules as the reason.
+func matchSize(name string, op fsnotify.Op, rules []sizeRule, sizes map[string]int64) (bool, []string, error) {
+	var srs []sizeRule
+	var reasons []string
+	for _, r := range rules {
+		if m, err := matchPatterns(name, []string{r.glob}); err != nil {
+			return false, nil, err
+		} else if m {
+			srs = append(srs, r)
+			reasons = append(reasons, r.String())
+		}
+	}
+	if srs == nil {
+		return true, nil, nil
+	}
+	if !op.Has(fsnotify.Write) && !op.Has(fsnotify.Create) {
+		return false, reasons, nil
+	}
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		// the file may be removed already.
+		logVerbose("stat size: %v", err)
+		return false, reasons, nil
+	}
+	prev, ok := sizes[name]
+	sizes[name] = fi.Size()
+	if !ok && !op.Has(fsnotify.Create) {
+		return false, reasons, nil // unknown previous size
+	}
+	for i, r := range srs {
+		if !r.satisfied(prev) && r.satisfied(fi.Size()) {
+			return true, reasons[i : i+1], nil
+		}
+	}
+	return false, reasons, nil
+}
+
+// initModes records the modes of the existing files in the targets (--on-chmod=exec).
+// The ignored directories are skipped.
+func initModes(targets, ignores []string, modes map[string]fs.FileMode) {
+	for _, t := range targets {
+		filepath.WalkDir(t, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			p = filepath.ToSlash(p)
+			if d.IsDir() {
+				if ign, _ := matchPatterns(p, ignores); ign {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if fi, err := os.Stat(p); err == nil {
+				modes[p] = fi.Mode()
+			}
+			return nil
+		})
+	}
+}
+
+// execChanged reports whether the CHMOD event changes the executable bits of the file.
+//
+// It is always true when modes is nil (--on-chmod=any) or the event is not only CHMOD,
+// and the mode is recorded in modes for the next event.
+// The CHMOD of the file whose previous mode is unknown does not trigger.
+func execChanged(name string, op fsnotify.Op, modes map[string]fs.FileMode) bool {
+	if modes == nil {
+		return true
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		delete(modes, name)
+		return op != fsnotify.Chmod
+	}
+	prev, ok := modes[name]
+	modes[name] = fi.Mode()
+	if op != fsnotify.Chmod {
+		return true
+	}
+	return ok && prev&0111 != fi.Mode()&0111
+}
+
+// smartIgnores is the temporary and backup files of the editors ignored by --smart.
+// "4913" is the file created by vim to check the permission of the directory.
+var smartIgnores = []string{"**/*~", "**/.#*", "**/*.swp", "**/*.swx", "**/4913"}
+
+// applySmart applies the presets of --smart to the options not given explicitly.
+//
+//   - ignore the temporary and backup files of the editors (added to --ignore)
+//   - filter the pure CHMOD events, which some editors do after the WRITE (--filter CHMOD)
+//   - merge the REMOVE and CREATE by the atomic save (--remove-grace 100ms)
+//   - debounce the events of a save shortly (--burst-policy debounce --delay 300ms)
+func applySmart(changed func(name string) bool) {
+	*ignores = append(*ignores, smartIgnores...)
+	if !changed("filter") {
+		*filters = []string{"CHMOD"}
+	}
+	if !changed("remove-grace") {
+		*removeGrace = 100 * time.Millisecond
+	}
+	if !changed("burst-policy") {
+		*burst = "debounce"
+	}
+	if !changed("delay") {
+		*delay = delayRange{300 * time.Millisecond, 300 * time.Millisecond}
+	}
+}
+
+// optArg is an option given in the command line.
+type optArg struct {
+	name, value string
+}
+
+// optArgs records the options in the order of the command line.
+var optArgs []optArg
+
+// oldFlagNames normalizes the old names of the options to the current ones for the compatibility.
+func oldFlagNames(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	switch name {
+	case "restart":
+		name = "restart-on-exit"
+	}
+	return pflag.NormalizedName(name)
+}
+
+// envOptions is the options which can be given by the environment variables.
+// The options given on the command line override the variables.
+var envOptions = []struct {
+	env   string
+	flag  string   // option set by the variable
+	given []string // options on the command line which override the variable
+	list  bool     // separated by --list-separator
+}{
+	{"ARELO_TARGETS", "target", []string{"target", "targets"}, true},
+	{"ARELO_PATTERNS", "pattern", []string{"pattern", "patterns"}, true},
+	{"ARELO_IGNORES", "ignore", []string{"ignore"}, true},
+	{"ARELO_DELAY", "delay", []string{"delay"}, false},
+	{"ARELO_SIGNAL", "signal", []string{"signal"}, false},
+	{"ARELO_RESTART", "restart-on-exit", []string{"restart-on-exit"}, false},
+}
+
+// applyEnvOptions sets the options not given on the command line from the environment variables.
+// The empty variables are ignored.
+// It returns the options set, to be placed before the ones on the command line.
+func applyEnvOptions(fs *pflag.FlagSet, getenv func(string) string, sep string) ([]optArg, error) {
+	var args []optArg
+	for _, e := range envOptions {
+		v := getenv(e.env)
+		if v == "" || slices.ContainsFunc(e.given, fs.Changed) {
+			continue
+		}
+		values := []string{v}
+		if e.list {
+			values = splitList(v, sep)
+		}
+		for _, v := range values {
+			if err := fs.Set(e.flag, v); err != nil {
+				return nil, xerrors.Errorf("%s: %w", e.env, err)
+			}
+			args = append(args, optArg{e.flag, v})
+		}
+	}
+	return args, nil
+}
+
+// splitList splits the list given by --targets or --patterns by the separator.
+// The separators in the braces of the glob (e.g. "**/*.{go,mod}") do not split.
+// The items are trimmed of the spaces, and the empty ones are dropped.
+func splitList(s, sep string) []string {
+	var items []string
+	add := func(item string) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '{':
+			depth++
+		case s[i] == '}' && depth > 0:
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			add(s[start:i])
+			i += len(sep) - 1
+			start = i + 1
+		}
+	}
+	add(s[start:])
+	return items
+}
+
+// expandListArgs replaces the --targets and --patterns in args with the --target and --pattern of each item.
+func expandListArgs(args []optArg, sep string) []optArg {
+	var expanded []optArg
+	for _, a := range args {
+		switch a.name {
+		case "targets":
+			for _, t := range splitList(a.value, sep) {
+				expanded = append(expanded, optArg{"target", t})
+			}
+		case "patterns":
+			for _, p := range splitList(a.value, sep) {
+				expanded = append(expanded, optArg{"pattern", p})
+			}
+		default:
+			expanded = append(expanded, a)
+		}
+	}
+	return expanded
+}
+
+// expandEnv replaces ${VAR} and $VAR in s by the environment variables, and "$$" by "$".
+// The unset variables are replaced by the empty string.
+// The special parameters of the shell (e.g. $1, $?) are left as is for the script given to the shell.
+func expandEnv(s string) string {
+	return os.Expand(s, func(name string) string {
+		switch {
+		case name == "$":
+			return "$"
+		case len(name) == 1 && strings.Contains("*#@!?-0123456789", name):
+			return "$" + name
+		}
+		return os.Getenv(name)
+	})
+}
+
+// expandCommand expands the variables in the COMMAND unless --no-expand-env,
+// which leaves the variables in the script given to the shell (e.g. `sh -c 'echo $f'`) for the shell.
+func expandCommand(cmd []string, expand bool) {
+	if expand {
+		expandEnvAll(cmd)
+	}
+}
+
+// expandEnvAll expands the variables of each string in ss.
+func expandEnvAll(ss []string) {
+	for i, s := range ss {
+		ss[i] = expandEnv(s)
+	}
+}
+
+// targetPats is the patterns associated with each target (--strict-target-patterns).
+var targetPats map[string][]string
+
+// targetPatterns associates the patterns with the targets by the order of the options.
+//
+// The patterns given after a target (until the next target) are associated with the target.
+// The patterns given before the first target are associated with all targets.
+// The target without any patterns matches any file ("**").
+func targetPatterns(args []optArg) map[string][]string {
+	var common []string
+	tps := make(map[string][]string)
+	var targets []string
+	cur := ""
+	for _, a := range args {
+		switch a.name {
+		case "target":
+			cur = path.Clean(a.value)
+			if _, ok := tps[cur]; !ok {
+				targets = append(targets, cur)
+				tps[cur] = nil
+			}
+		case "pattern":
+			if cur == "" {
+				common = append(common, a.value)
+			} else {
+				tps[cur] = append(tps[cur], a.value)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		targets = []string{"."}
+	}
+	for _, t := range targets {
+		pats := append(common[:len(common):len(common)], tps[t]...)
+		if len(pats) == 0 {
+			pats = []string{"**"}
+		}
+		tps[t] = pats
+	}
+	return tps
+}
+
+// patternsFor returns the patterns associated with the innermost target containing the file.
+func patternsFor(name string, tps map[string][]string) []string {
+	name = path.Clean(name)
+	var pats []string
+	best := -1
+	for t, p := range tps {
+		if isUnder(name, t) && len(t) > best {
+			best = len(t)
+			pats = p
+		}
+	}
+	return pats
+}
+
+// isUnder reports whether the cleaned path name is dir or under dir.
+func isUnder(name, dir string) bool {
+	switch {
+	case name == dir:
+		return true
+	case dir == ".":
+		return !path.IsAbs(name) && name != ".." && !strings.HasPrefix(name, "../")
+	case dir == "/":
+		return path.IsAbs(name)
+	}
+	return strings.HasPrefix(name, dir+"/")
+}
+
+// fsWatcher is the backend of the watcher: *fsnotify.Watcher, or fanotify on Linux.
+type fsWatcher interface {
+	Add(name string) error
+	Remove(name string) error
+	Close() error
+}
+
+func watcher(targets, patterns, ignores []string, filtOp fsnotify.Op) (<-chan trigger, <-chan error, error) {
+	var w fsWatcher
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	dirs := make(map[string]bool)
+	var links map[string]string
+	if *resolveLinks {
+		links = make(map[string]string)
+	}
+	maxw := *maxWatches
+	if *watchMode == "fanotify" {
+		fw, ev, er, err := newFanotifyWatcher(*onAccess)
+		if err == nil {
+			err = addTargets(fw, targets, patterns, ignores, dirs, links, maxw)
+			if err != nil {
+				fw.Close()
+			}
+		}
+		if err != nil {
+			log.Printf("[ARELO] warning: fanotify is not available, fall back to fsnotify: %v", err)
+			if *onAccess {
+				log.Printf("[ARELO] warning: the access to the files is not reported by fsnotify")
+			}
+			clear(dirs)
+			clear(links)
+		} else {
+			w, events, errs = fw, ev, er
+		}
+	}
+	if w == nil {
+		fw, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := addTargets(fw, targets, patterns, ignores, dirs, links, maxw); err != nil {
+			return nil, nil, err
+		}
+		w, events, errs = fw, fw.Events, fw.Errors
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getwd: %w", err)
+	}
+	var guards map[string]map[string]string
+	var files map[string]bool
+	if *watchTargetPar {
+		guards, files = watchParents(w, cwd, targets, dirs)
+	}
+	isTarget := make(map[string]bool) // cleaned targets
+	for _, t := range targets {
+		isTarget[path.Clean(t)] = true
+	}
+	also, alsoParents, err := watchAlso(w, cwd, *watchAlsoOpt, dirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *hookWatchSet && *hookURL != "" {
+		w = hookWatcher{w, *hookURL}
+	}
+	rate := newEventRate(*maxEventRate)
+	cooldown := newFileCooldown(*cooldownOpt)
+	var summaryC <-chan time.Time // log the summary instead of each event on verbose level 1
+	summaryInterval := *eventSummary
+	if summaryInterval > 0 && *verbose < 2 {
+		summaryC = time.NewTicker(summaryInterval).C
+	}
+	var nEvents, nMatched int
+
+	modC := make(chan trigger)
+	errC := make(chan error)
+	dirchg := *dirchg
+	explain := *explain
+	targetPats := targetPats
+	noExisting := *noExisting
+	contentRules := contentRules
+	sizeRules := sizeRules
+	symlinkCreate := *symlinkCreate
+	older, newer := *ignoreOlder, *ignoreNewer
+	sizes := make(map[string]int64) // previous sizes of the files matched to the size rules
+	initSizes(targets, sizeRules, sizes)
+	var modes map[string]fs.FileMode // previous modes of the files (--on-chmod=exec)
+	if *onChmod == "exec" {
+		modes = make(map[string]fs.FileMode)
+		initModes(targets, ignores, modes)
+	}
+	grace := *removeGrace
+	removed := make(map[string]int) // removed files waiting for the grace window
+	expired := make(chan heldTrigger)
+	debounce := *debounceOpt
+	lowOps := debounceLowOps
+	pending := make(map[string]heldTrigger) // triggers coalesced until the file is quiet
+	quiet := make(chan heldTrigger)
+	var seq int
+	var lastFile trigger // last event of the file targets, reported by the file and its parent
+	var lastFileAt time.Time
+	gone := make(map[string]bool) // directories removed, whose removal is reported by itself and its parent
+
+	go func() {
+		defer close(modC)
+		for {
+			select {
+			case <-summaryC:
+				if nEvents > 0 {
+					logVerbose("%d events in last %v (matched %d)", nEvents, summaryInterval, nMatched)
+					nEvents, nMatched = 0, 0
+				}
+
+			case r := <-expired:
+				if removed[r.name] == r.seq {
+					delete(removed, r.name)
+					modC <- r.trigger
+				}
+
+			case q := <-quiet:
+				if p, ok := pending[q.name]; ok && p.seq == q.seq {
+					delete(pending, q.name)
+					modC <- p.trigger
+				}
+
+			case event, ok := <-events:
+				if !ok {
+					errC <- xerrors.Errorf("watcher.Events closed")
+					return
+				}
+
+				name := filepath.ToSlash(event.Name)
+				if l, ok := links[name]; ok {
+					name = l
+				}
+
+				// the files given by --watch-also trigger regardless of the patterns and the ignores.
+				if len(also) > 0 {
+					if a, ok := also[absPath(cwd, name)]; ok {
+						if isWatchedOp(event.Op, filtOp) {
+							logExplain(explain, "%v %q: watched also", opString(event.Op), a)
+							nMatched++
+							modC <- trigger{name: a, op: event.Op}
+						} else {
+							logExplain(explain, "%v %q: filtered", opString(event.Op), a)
+						}
+						continue
+					}
+				}
+
+				// the events in the parents of the targets: the targets or the parents are renamed or removed,
+				// or the targets are created again.
+				if len(guards) > 0 {
+					abs := absPath(cwd, name)
+					if ts, ok := guards[abs]; ok && (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) {
+						for _, t := range ts {
+							log.Printf("[ARELO] warning: the parent of the target %q is renamed or removed, it is no longer watched", t)
+							unwatch(w, t, dirs)
+						}
+						delete(guards, abs)
+						continue
+					}
+					if ts, ok := guards[filepath.Dir(abs)]; ok {
+						// the events of the file targets go on to trigger, the others stop here.
+						t, ok := ts[abs]
+						switch {
+						case !ok:
+							continue
+						case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+							if dirs[t] {
+								log.Printf("[ARELO] warning: target %q is renamed or removed, it is watched again when created", t)
+								unwatch(w, t, dirs)
+								continue
+							}
+							if !files[abs] {
+								continue // already reported by the file or its parent
+							}
+							w.Remove(t) // not to follow the renamed file
+							delete(files, abs)
+						case event.Has(fsnotify.Create):
+							fi, err := os.Stat(t)
+							if err != nil {
+								continue
+							}
+							if fi.IsDir() {
+								if !dirs[t] {
+									log.Printf("[ARELO] target %q is created again as a directory", t)
+									pats := patterns
+									if targetPats != nil {
+										pats = patternsFor(t, targetPats)
+									}
+									var ch chan<- trigger = modC // trigger on the existing files in the target
+									if noExisting {
+										ch = nil
+									}
+									err := addDirRecursive(w, fi, t, pats, ignores, dirs, maxw, ch)
+									if err != nil && !xerrors.Is(err, errMaxWatches) {
+										errC <- err
+										return
+									}
+								}
+								continue
+							}
+							if !fi.Mode().IsRegular() {
+								continue
+							}
+							if !files[abs] {
+								logVerbose("target %q is created again as a file", t)
+								if err := w.Add(t); err != nil {
+									logVerbose("cannot watch the target %q: %v", t, err)
+								}
+								files[abs] = true
+							}
+						case !files[abs]:
+							continue // e.g. CHMOD of the directory target
+						default:
+							// the same event is reported by the file and its parent.
+							e := trigger{name: abs, op: event.Op}
+							if e == lastFile && time.Since(lastFileAt) < time.Second/10 {
+								continue
+							}
+							lastFile, lastFileAt = e, time.Now()
+						}
+					}
+				}
+				if len(alsoParents) > 0 && alsoParents[filepath.Dir(absPath(cwd, name))] {
+					continue // the other files in the parents watched only for --watch-also
+				}
+				if summaryC != nil {
+					nEvents++
+				} else {
+					logVerbose("event: %v %q", opString(event.Op), name)
+				}
+				if top, c, warn := rate.add(name, time.Now()); warn {
+					log.Printf("[ARELO] warning: more than %d events/s, check your ignores (most frequent: %q, %d events)", rate.limit, top, c)
+				}
+
+				pats := patterns
+				if targetPats != nil {
+					pats = patternsFor(name, targetPats)
+				}
+
+				if ignore, err := matchedPattern(name, ignores); err != nil {
+					errC <- xerrors.Errorf("match ignores: %w", err)
+					return
+				} else if ignore != "" {
+					// the ignored new directory is not watched either (e.g. node_modules).
+					logExplain(explain, "%v %q: ignored by %q", opString(event.Op), name, ignore)
+					continue
+				}
+
+				if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+					if d := path.Clean(name); gone[d] {
+						delete(gone, d)
+						logExplain(explain, "%v %q: already reported", opString(event.Op), name)
+						continue
+					}
+				} else if event.Has(fsnotify.Create) {
+					delete(gone, path.Clean(name))
+				}
+
+				merged := false
+				if _, ok := removed[name]; ok && event.Has(fsnotify.Create) {
+					// the file is rewritten, not removed nor created.
+					// the held removal is canceled here, even if the creation is dropped by the filters below.
+					delete(removed, name)
+					merged = true
+					logVerbose("merged remove and create: %q", name)
+				}
+
+				forwarded := false // the trigger of the event is sent (or held) by the pattern match
+				if isWatchedOp(event.Op, filtOp) {
+					if match, err := matchedPattern(name, pats); err != nil {
+						errC <- xerrors.Errorf("match patterns: %w", err)
+						return
+					} else if match == "" {
+						logExplain(explain, "%v %q: not matched", opString(event.Op), name)
+					} else if isSpecialFile(name) {
+						logExplain(explain, "%v %q: matched by %q, but special file", opString(event.Op), name, match)
+					} else if !symlinkCreate && event.Has(fsnotify.Create) && isDanglingSymlink(name) {
+						logExplain(explain, "%v %q: matched by %q, but dangling symlink", opString(event.Op), name, match)
+					} else if age := ignoredAge(name, older, newer); age != "" {
+						logExplain(explain, "%v %q: matched by %q, but ignored by mtime (%s)", opString(event.Op), name, match, age)
+					} else if !execChanged(name, event.Op, modes) {
+						logExplain(explain, "%v %q: matched by %q, but executable bits not changed", opString(event.Op), name, match)
+					} else if ok, rules, err := matchContent(name, event.Op, contentRules); err != nil {
+						errC <- xerrors.Errorf("match contents: %w", err)
+						return
+					} else if !ok {
+						logExplain(explain, "%v %q: matched by %q, but content not matched by %q", opString(event.Op), name, match, rules)
+					} else if ok, srules, err := matchSize(name, event.Op, sizeRules, sizes); err != nil {
+						errC <- xerrors.Errorf("match sizes: %w", err)
+						return
+					} else if !ok {
+						logExplain(explain, "%v %q: matched by %q, but size not crossed %q", opString(event.Op), name, match, srules)
+					} else if !cooldown.allow(name, time.Now()) {
+						logVerbose("cooling down: %q", name)
+						logExplain(explain, "%v %q: matched by %q, but cooling down", opString(event.Op), name, match)
+					} else {
+						logExplain(explain, "%v %q: matched by %q", opString(event.Op), name, match)
+						if rules != nil {
+							logExplain(explain, "%v %q: content matched by %q", opString(event.Op), name, rules[0])
+						}
+						if srules != nil {
+							logExplain(explain, "%v %q: size crossed %q", opString(event.Op), name, srules[0])
+						}
+						nMatched++
+						forwarded = true
+						if grace > 0 && event.Op == fsnotify.Remove {
+							// hold the removal, editors may create the file again soon.
+							seq++
+							removed[name] = seq
+							r := heldTrigger{trigger{name: name, op: event.Op, pattern: match}, seq}
+							time.AfterFunc(grace, func() { expired <- r })
+						} else {
+							t := trigger{name: name, op: event.Op, pattern: match}
+							if merged {
+								t.op = fsnotify.Write
+							}
+							if debounce > 0 {
+								// coalesce the events of a save (e.g. CREATE, WRITE and CHMOD) into a trigger.
+								key := path.Clean(name)
+								if p, ok := pending[key]; ok && !isWatchedOp(t.op, lowOps) {
+									// e.g. the chmod by the editor after the save does not extend the quiet period.
+									p.op |= t.op
+									pending[key] = p
+									logVerbose("coalesced low priority %v: %q", opString(t.op), name)
+								} else {
+									if ok {
+										t.op |= p.op
+										logVerbose("coalesced: %v %q", opString(t.op), name)
+									}
+									seq++
+									pending[key] = heldTrigger{t, seq}
+									q := heldTrigger{trigger{name: key}, seq}
+									time.AfterFunc(debounce, func() { quiet <- q })
+								}
+							} else {
+								modC <- t
+							}
+						}
+					}
+				} else {
+					logExplain(explain, "%v %q: filtered", opString(event.Op), name)
+				}
+
+				// forget the directory if removed.
+				if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+					if d := path.Clean(name); dirs[d] {
+						gone[d] = true
+						if isTarget[d] {
+							// the watches follow the renamed directories, so drop them not to report the old paths.
+							log.Printf("[ARELO] warning: target %q is renamed or removed, it is no longer watched", d)
+							unwatch(w, d, dirs)
+						} else {
+							w.Remove(d) // the watch may be already removed by the backend, or follows the renamed directory.
+							delete(dirs, d)
+							session.watching(len(dirs))
+							logVerbose("unwatched: %q", d)
+						}
+						if dirchg && !forwarded && isWatchedOp(event.Op, filtOp) {
+							modC <- trigger{name: name, op: event.Op}
+						}
+					}
+				}
+
+				// add watcher if new directory.
+				if event.Has(fsnotify.Create) {
+					fi, err := os.Stat(name)
+					if err != nil {
+						// ignore stat errors (notfound, permission, etc.)
+						if isDanglingSymlink(name) {
+							logVerbose("dangling symlink: %q", name)
+						} else {
+							log.Printf("[ARELO] watcher: %v", err)
+						}
+					} else if fi.IsDir() {
+						var ch chan<- trigger = modC // trigger on the existing files in the new directory
+						if noExisting {
+							ch = nil
+						}
+						err := addDirRecursive(w, fi, name, pats, ignores, dirs, maxw, ch)
+						if err != nil && !xerrors.Is(err, errMaxWatches) {
+							errC <- err
+							return
+						}
+						if dirchg && !forwarded && isWatchedOp(event.Op, filtOp) {
+							modC <- trigger{name: name, op: event.Op}
+						}
+					}
+				}
+
+			case err, ok := <-errs:
+				errC <- xerrors.Errorf("watcher.Errors (%v): %w", ok, err)
+				return
+			}
+		}
+	}()
+
+	return modC, errC, nil
+}
+
+// fileCooldown limits the triggers by each file to once in the interval (--file-cooldown).
+type fileCooldown struct {
+	interval time.Duration
+	last     map[string]time.Time // last trigger time of each file
+}
+
+func newFileCooldown(interval time.Duration) *fileCooldown {
+	return &fileCooldown{interval: interval, last: make(map[string]time.Time)}
+}
+
+// allow reports whether the file can trigger at now, and records it if so.
+func (c *fileCooldown) allow(name string, now time.Time) bool {
+	if c.interval <= 0 {
+		return true
+	}
+	if t, ok := c.last[name]; ok && now.Sub(t) < c.interval {
+		return false
+	}
+	if len(c.last) >= 1024 {
+		// forget the cooled files not to grow.
+		for n, t := range c.last {
+			if now.Sub(t) >= c.interval {
+				delete(c.last, n)
+			}
+		}
+	}
+	c.last[name] = now
+	return true
+}
+
+// eventRate counts the events in each second to warn the event storms (--max-event-rate).
+type eventRate struct {
+	limit  int
+	start  time.Time      // start of the current second
+	count  int            // events in the current second
+	paths  map[string]int // events of each path in the current second
+	warned time.Time
+}
+
+func newEventRate(limit int) *eventRate {
+	return &eventRate{limit: limit, paths: make(map[string]int)}
+}
+
+// add counts the event of the name.
+// When the events in the current second exceed the limit,
+// it returns the most frequent path and its count, and true to warn.
+// The warning is returned at most once in eventRateWarnInterval.
+func (r *eventRate) add(name string, now time.Time) (string, int, bool) {
+	if r.limit <= 0 {
+		return "", 0, false
+	}
+	if now.Sub(r.start) >= time.Second {
+		r.start = now
+		r.count = 0
+		clear(r.paths)
+	}
+	r.count++
+	r.paths[name]++
+	if r.count <= r.limit || now.Sub(r.warned) < eventRateWarnInterval {
+		return "", 0, false
+	}
+	r.warned = now
+	var top string
+	var c int
+	for p, n := range r.paths {
+		if n > c || (n == c && p < top) {
+			top, c = p, n
+		}
+	}
+	return top, c, true
+}
+
+// watchParents watches the parents of the directory targets and the regular file targets (--watch-target-parents),
+// to know the targets are renamed or removed, and created again (may be as the other type).
+// The parents which are watched as a part of the targets, and the parent of the current directory are not watched.
+// The file target is still watched by itself, and its parent reports the same events.
+// It returns the map of the absolute paths of the parents to the absolute paths of the targets to the targets,
+// and the set of the absolute paths of the file targets.
+func watchParents(w fsWatcher, cwd string, targets []string, dirs map[string]bool) (map[string]map[string]string, map[string]bool) {
+	guards := make(map[string]map[string]string)
+	files := make(map[string]bool)
+	for _, t := range targets {
+		t = path.Clean(t)
+		p := path.Join(t, "..")
+		if p == t || dirs[p] {
+			continue
+		}
+		abs := absPath(cwd, t)
+		if abs == filepath.Clean(cwd) {
+			continue // the siblings of the current directory are not to be watched
+		}
+		file := false
+		if !dirs[t] {
+			// the symlink is watched as the file it points to.
+			if fi, err := os.Lstat(t); err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			file = true
+		}
+		pabs := filepath.Dir(abs)
+		if guards[pabs] == nil {
+			if err := w.Add(p); err != nil {
+				logVerbose("cannot watch the parent of the target %q: %v", t, err)
+				continue
+			}
+			guards[pabs] = make(map[string]string)
+		}
+		guards[pabs][abs] = t
+		if file {
+			files[abs] = true
+		}
+	}
+	return guards, files
+}
+
+// watchAlso watches the parents of the files given by --watch-also,
+// to know the files are modified, or replaced by the editors.
+// It returns the map of the absolute paths of the files to the given names,
+// and the absolute paths of the parents watched only for them (not a part of the targets).
+func watchAlso(w fsWatcher, cwd string, files []string, dirs map[string]bool) (map[string]string, map[string]bool, error) {
+	also := make(map[string]string)
+	parents := make(map[string]bool)
+	for _, f := range files {
+		f = path.Clean(filepath.ToSlash(f))
+		abs := absPath(cwd, f)
+		also[abs] = f
+		p := path.Dir(f)
+		if dirs[p] {
+			continue
+		}
+		pabs := filepath.Dir(abs)
+		if !parents[pabs] {
+			logVerbose("watching the parent of %q", f)
+			if err := w.Add(p); err != nil {
+				return nil, nil, xerrors.Errorf("watch also %q: %w", f, err)
+			}
+			parents[pabs] = true
+		}
+	}
+	return also, parents, nil
+}
+
+// absPath returns the absolute path of the name in the cwd.
+func absPath(cwd, name string) string {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+	return filepath.Join(cwd, name)
+}
+
+// unwatch removes the watches of the directory t and its subdirectories.
+func unwatch(w fsWatcher, t string, dirs map[string]bool) {
+	for d := range dirs {
+		if isUnder(d, t) {
+			w.Remove(d) // the watch may be already removed by the backend.
+			delete(dirs, d)
+			session.watching(len(dirs))
+			logVerbose("unwatched: %q", d)
+		}
+	}
+}
+
+// gitWatcher polls `git status --porcelain` of the targets in the dir on each interval until ctx is done.
+// It triggers when the status of a file matched to the patterns has changed.
+func gitWatcher(ctx context.Context, dir string, targets, patterns, ignores []string, interval time.Duration) (<-chan trigger, <-chan error, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, nil, xerrors.Errorf("git-status mode requires git: %w", err)
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return nil, nil, xerrors.Errorf("git rev-parse: %w", err)
+	}
+	prefix := strings.TrimSpace(string(out)) // the dir relative to the root of the repository
+	prev, err := gitStatus(dir, prefix, targets)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	modC := make(chan trigger)
+	errC := make(chan error, 1)
+
+	go func() {
+		defer close(modC)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			cur, err := gitStatus(dir, prefix, targets)
+			if err != nil {
+				errC <- err
+				return
+			}
+			var changed []string
+			for name, st := range cur {
+				if prev[name] != st {
+					changed = append(changed, name)
+				}
+			}
+			for name := range prev {
+				if _, ok := cur[name]; !ok {
+					changed = append(changed, name)
+				}
+			}
+			old := prev
+			prev = cur
+			sort.Strings(changed)
+
+			for _, name := range changed {
+				logVerbose("git status: %q %q -> %q", name, old[name], cur[name])
+				if ignore, err := matchPatterns(name, ignores); err != nil {
+					errC <- xerrors.Errorf("match ignores: %w", err)
+					return
+				} else if ignore {
+					continue
+				}
+				if match, err := matchedPattern(name, patterns); err != nil {
+					errC <- xerrors.Errorf("match patterns: %w", err)
+					return
+				} else if match != "" {
+					select {
+					case modC <- trigger{name: name, op: gitOp(old[name], cur[name]), pattern: match}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return modC, errC, nil
+}
+
+// gitOp returns the operation of the file guessed from the change of its status code,
+// so that the triggers of the git-status mode are treated as the file changes (e.g. --min-changed-files).
+func gitOp(old, cur string) fsnotify.Op {
+	switch {
+	case strings.Contains(cur, "D"):
+		return fsnotify.Remove
+	case old == "" && cur == "??":
+		return fsnotify.Create
+	}
+	return fsnotify.Write
+}
+
+// gitStatus returns the status code of each file reported by `git status --porcelain`.
+// The paths are reported relative to the root of the repository, so they are made relative to the dir by its prefix.
+func gitStatus(dir, prefix string, targets []string) (map[string]string, error) {
+	args := append([]string{"-C", dir, "status", "--porcelain", "-z", "--untracked-files=all", "--"}, targets...)
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, xerrors.Errorf("git status: %w: %s", err, bytes.TrimSpace(ee.Stderr))
+		}
+		return nil, xerrors.Errorf("git status: %w", err)
+	}
+	st := make(map[string]string)
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		l := entries[i]
+		if len(l) < 4 {
+			continue
+		}
+		if l[0] == 'R' || l[0] == 'C' {
+			i++ // the original path of the renamed or copied file follows
+		}
+		name, err := filepath.Rel(filepath.FromSlash("/"+prefix), filepath.FromSlash("/"+l[3:]))
+		if err != nil {
+			return nil, xerrors.Errorf("git status: %w", err)
+		}
+		st[filepath.ToSlash(name)] = l[:2]
+	}
+	return st, nil
+}
+
+// ignoredAge returns the reason if the modification time of the file is out of the range.
+// The file is ignored when it is modified more than older ago, or less than newer ago.
+// The zero duration disables each check, and the file is not stat-ed when both are zero.
+func ignoredAge(name string, older, newer time.Duration) string {
+	if older == 0 && newer == 0 {
+		return ""
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return "" // removed files are not ignored
+	}
+	age := time.Since(fi.ModTime())
+	if older > 0 && age > older {
+		return fmt.Sprintf("modified %v ago, older than %v", age, older)
+	}
+	if newer > 0 && age < newer {
+		return fmt.Sprintf("modified %v ago, newer than %v", age, newer)
+	}
+	return ""
+}
+
+// isSpecialFile reports whether the file is a device, FIFO, socket or other irregular file.
+// Such files (e.g. the socket created by the command) do not trigger.
+func isSpecialFile(name string) bool {
+	fi, err := os.Lstat(name)
+	return err == nil && isSpecialMode(fi.Mode())
+}
+
+func isSpecialMode(m fs.FileMode) bool {
+	return m&(fs.ModeDevice|fs.ModeCharDevice|fs.ModeNamedPipe|fs.ModeSocket|fs.ModeIrregular) != 0
+}
+
+// isDanglingSymlink reports whether the file is a symlink whose target does not exist.
+func isDanglingSymlink(name string) bool {
+	fi, err := os.Lstat(name)
+	if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		return false
+	}
+	_, err = os.Stat(name)
+	return xerrors.Is(err, fs.ErrNotExist)
+}
+
+// trigger is the cause of the restart.
+type trigger struct {
+	name    string
+	op      fsnotify.Op
+	pattern string // matched pattern
+}
+
+func (t trigger) String() string {
+	s := fmt.Sprintf("%q", t.name)
+	if t.op != 0 {
+		s = opString(t.op) + " " + s
+	}
+	if t.pattern != "" {
+		s += fmt.Sprintf(" matched by %q", t.pattern)
+	}
+	return s
+}
+
+// heldTrigger is a trigger held for the grace window of the removal (--remove-grace),
+// or until the file is quiet (--debounce).
+type heldTrigger struct {
+	trigger
+	seq int
+}
+
+func matchPatterns(t string, pats []string) (bool, error) {
+	p, err := matchedPattern(t, pats)
+	return p != "", err
+}
+
+// matchedPattern returns the first pattern matched to t, or "" if none.
+func matchedPattern(t string, pats []string) (string, error) {
+	for _, p := range pats {
+		if m, ok := fastMatch(p, t); ok {
+			if m {
+				return p, nil
+			}
+			continue
+		}
+		m, err := doublestar.Match(p, t)
+		if err != nil {
+			return "", xerrors.Errorf("match(%v, %v): %w", p, t, err)
+		}
+		if m {
+			return p, nil
+		}
+		if strings.HasPrefix(t, "./") {
+			m, err = doublestar.Match(p, t[2:])
+			if err != nil {
+				return "", xerrors.Errorf("match(%v, %v): %w", p, t[2:], err)
+			}
+			if m {
+				return p, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// fastMatch matches the common patterns without parsing them:
+// "**" and the extension-only patterns like "**/*.go".
+// ok is false if the pattern is not such a pattern.
+func fastMatch(p, t string) (matched, ok bool) {
+	if p == "**" {
+		return true, true
+	}
+	if strings.HasPrefix(p, "**/*.") && !strings.ContainsAny(p[5:], "*?[]{}\\/") {
+		return strings.HasSuffix(t, p[4:]), true
+	}
+	return false, false
+}
+
+// validatePatterns checks the syntax of the patterns given by the option.
+func validatePatterns(opt string, pats []string) error {
+	for _, p := range pats {
+		if !doublestar.ValidatePattern(p) {
+			return xerrors.Errorf("invalid %s %q: %w", opt, p, doublestar.ErrBadPattern)
+		}
+	}
+	return nil
+}
+
+// listWatcher is the fsWatcher which only records the added paths (--print-watched-tree).
+type listWatcher map[string]bool
+
+func (w listWatcher) Add(name string) error {
+	w[path.Clean(filepath.ToSlash(name))] = true
+	return nil
+}
+
+func (w listWatcher) Remove(name string) error {
+	delete(w, path.Clean(filepath.ToSlash(name)))
+	return nil
+}
+
+func (w listWatcher) Close() error {
+	return nil
+}
+
+// printWatchedTree prints the paths to be watched for the targets as an indented tree.
+// Each path is printed relative to the nearest watched ancestor, and the directories end with "/".
+func printWatchedTree(out io.Writer, targets, patterns, ignores []string, maxw int) error {
+	w := make(listWatcher)
+	dirs := make(map[string]bool)
+	if err := addTargets(w, targets, patterns, ignores, dirs, nil, maxw); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(w))
+	for n := range w {
+		names = append(names, n)
+	}
+	// sort by the path elements, so that the children follow their parent.
+	slices.SortFunc(names, func(a, b string) int {
+		return strings.Compare(strings.ReplaceAll(a, "/", "\x00"), strings.ReplaceAll(b, "/", "\x00"))
+	})
+
+	depth := make(map[string]int)
+	for _, n := range names {
+		d, label := 0, n
+		for p := path.Dir(n); p != n; p = path.Dir(p) {
+			if w[p] {
+				d = depth[p] + 1
+				label = strings.TrimPrefix(n, strings.TrimSuffix(p, "/")+"/")
+				break
+			}
+			if p == path.Dir(p) {
+				break // "." or "/"
+			}
+		}
+		depth[n] = d
+		if dirs[n] && !strings.HasSuffix(label, "/") {
+			label += "/"
+		}
+		if _, err := fmt.Fprintf(out, "%s%s\n", strings.Repeat("  ", d), label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTargets adds the targets to the watcher.
+// If links is not nil, the symlink file targets are resolved and
+// the resolved paths are recorded to links to map them back.
+func addTargets(w fsWatcher, targets, patterns, ignores []string, dirs map[string]bool, links map[string]string, maxw int) error {
+	for _, t := range targets {
+		t = path.Clean(t)
+		fi, err := os.Stat(t)
+		if err != nil {
+			return xerrors.Errorf("stat: %w", err)
+		}
+		if isSpecialMode(fi.Mode()) {
+			log.Printf("[ARELO] warning: target %q is a special file (%v), not watched", t, fi.Mode().Type())
+			continue
+		}
+		if ign, err := coveringIgnore(t, fi.IsDir(), ignores); err != nil {
+			return xerrors.Errorf("match ignores: %w", err)
+		} else if ign != "" {
+			log.Printf("[ARELO] warning: target %q is entirely ignored by %q", t, ign)
+		}
+		if fi.IsDir() {
+			if err := addDirRecursive(w, fi, t, patterns, ignores, dirs, maxw, nil); xerrors.Is(err, errMaxWatches) {
+				continue
+			} else if err != nil {
+				return err
+			}
+		} else if links != nil {
+			r, err := filepath.EvalSymlinks(t)
+			if err != nil {
+				return xerrors.Errorf("eval symlinks: %w", err)
+			}
+			if r = filepath.ToSlash(r); r != t {
+				logVerbose("resolved symlink: %q -> %q", t, r)
+				links[r] = t
+				t = r
+			}
+		}
+		logVerbose("watching target: %q", t)
+		if err := w.Add(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coveringIgnore returns the ignore pattern which covers the whole target, or "" if none.
+//
+// A file target is covered when the ignore matches it.
+// A directory target is covered when the ignore matches any file directly under it,
+// since the subdirectories are not watched when they are ignored.
+func coveringIgnore(t string, isDir bool, ignores []string) (string, error) {
+	if isDir {
+		// a name which no sane pattern expects literally.
+		t = path.Join(t, "\x00arelo\x00")
+	}
+	return matchedPattern(t, ignores)
+}
+
+// errMaxWatches is returned by addDirRecursive when the number of the watched directories reaches --max-watches.
+var errMaxWatches = xerrors.New("max watches reached")
+
+// addDirRecursive adds the directory and its subdirectories to the watcher.
+// If maxw is not 0, it stops adding the directories when len(dirs) reaches maxw and returns errMaxWatches.
+func addDirRecursive(w fsWatcher, fi fs.FileInfo, t string, patterns, ignores []string, dirs map[string]bool, maxw int, ch chan<- trigger) error {
+	if maxw > 0 && len(dirs) >= maxw {
+		log.Printf("[ARELO] warning: max watches (%d) reached, %q and the rest are not watched", maxw, t)
+		return errMaxWatches
+	}
+	logVerbose("watching target: %q", t)
+	err := w.Add(t)
+	if xerrors.Is(err, fs.ErrNotExist) {
+		// removed while walking (e.g. git checkout, build)
+		logVerbose("vanished: %q", t)
+		return nil
+	} else if err != nil {
+		return xerrors.Errorf("wacher add: %w", err)
+	}
+	dirs[path.Clean(t)] = true
+	session.watching(len(dirs))
+	des, err := os.ReadDir(t)
+	if xerrors.Is(err, fs.ErrNotExist) {
+		logVerbose("vanished: %q", t)
+		return nil
+	} else if err != nil {
+		return xerrors.Errorf("read dir: %w", err)
+	}
+	for _, de := range des {
+		name := path.Join(t, de.Name())
+		if ignore, err := matchPatterns(name, ignores); err != nil {
+			return xerrors.Errorf("match ignores: %w", err)
+		} else if ignore {
+			continue
+		}
+		if isSpecialMode(de.Type()) {
+			logVerbose("skip special file: %q (%v)", name, de.Type())
+			continue
+		}
+		if ch != nil {
+			if match, err := matchedPattern(name, patterns); err != nil {
+				return xerrors.Errorf("match patterns: %w", err)
+			} else if match != "" {
+				ch <- trigger{name: name, op: fsnotify.Create, pattern: match}
+			}
+		}
+		if de.IsDir() {
+			fi, err := de.Info()
+			if xerrors.Is(err, fs.ErrNotExist) {
+				logVerbose("vanished: %q", name)
+				continue
+			} else if err != nil {
+				return err
 			}
-			err = addDirRecursive(w, fi, name, patterns, ignores, ch)
+			err = addDirRecursive(w, fi, name, patterns, ignores, dirs, maxw, ch)
 			if err != nil {
 				return err
 			}
@@ -314,30 +2249,179 @@ func addDirRecursive(w *fsnotify.Watcher, fi fs.FileInfo, t string, patterns, ig
 	return nil
 }
 
-type bytesErr struct {
-	bytes []byte
-	err   error
+type bytesErr struct {
+	bytes []byte
+	err   error
+}
+
+// stdinReader bypasses stdin to child processes
+//
+// cmd.Wait() blocks until stdin.Read() returns.
+// so stdinReader.Read() returns EOF when the child process exited.
+type stdinReader struct {
+	input    <-chan bytesErr
+	chldDone <-chan struct{}
+}
+
+func (s *stdinReader) Read(b []byte) (int, error) {
+	select {
+	case be, ok := <-s.input:
+		if !ok {
+			return 0, io.EOF
+		}
+		return copy(b, be.bytes), be.err
+	case <-s.chldDone:
+		return 0, io.EOF
+	}
+}
+
+// delayRange is the value of --delay, a duration or a range "min-max" of the random duration.
+type delayRange struct {
+	min, max time.Duration
+}
+
+func delayRangeP(name, shorthand string, value time.Duration, usage string) *delayRange {
+	d := &delayRange{value, value}
+	pflag.VarP(d, name, shorthand, usage)
+	return d
+}
+
+func (d *delayRange) String() string {
+	if d.min == d.max {
+		return d.min.String()
+	}
+	return d.min.String() + "-" + d.max.String()
+}
+
+func (d *delayRange) Set(s string) error {
+	if strings.HasPrefix(s, "-") {
+		return xerrors.New("must not be negative")
+	}
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		hi = lo
+	}
+	min, err := time.ParseDuration(lo)
+	if err != nil {
+		return err
+	}
+	max, err := time.ParseDuration(hi)
+	if err != nil {
+		return err
+	}
+	if min > max {
+		return xerrors.Errorf("min %v is greater than max %v", min, max)
+	}
+	d.min, d.max = min, max
+	return nil
+}
+
+func (d *delayRange) Type() string {
+	return "duration"
 }
 
-// stdinReader bypasses stdin to child processes
-//
-// cmd.Wait() blocks until stdin.Read() returns.
-// so stdinReader.Read() returns EOF when the child process exited.
-type stdinReader struct {
-	input    <-chan bytesErr
-	chldDone <-chan struct{}
+// verboseLevel is the value of --verbose, counted by the repeats like -vv,
+// or given as a bool (--verbose=true) or a number (--verbose=2).
+type verboseLevel int
+
+func verboseLevelP(name, shorthand string, usage string) *verboseLevel {
+	v := new(verboseLevel)
+	pflag.VarP(v, name, shorthand, usage)
+	pflag.Lookup(name).NoOptDefVal = "+1"
+	return v
 }
 
-func (s *stdinReader) Read(b []byte) (int, error) {
-	select {
-	case be, ok := <-s.input:
-		if !ok {
-			return 0, io.EOF
+func (v *verboseLevel) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *verboseLevel) Set(s string) error {
+	if s == "+1" {
+		*v++
+		return nil
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		*v = 0
+		if b {
+			*v = 1
 		}
-		return copy(b, be.bytes), be.err
-	case <-s.chldDone:
-		return 0, io.EOF
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return xerrors.Errorf("must be a bool or a count: %s", s)
+	}
+	*v = verboseLevel(n)
+	return nil
+}
+
+func (v *verboseLevel) Type() string {
+	return "count"
+}
+
+// debounceLowOps is the operations not extending the delay on "debounce" policy
+// nor the quiet period of --debounce, set in main.
+var debounceLowOps fsnotify.Op = fsnotify.Chmod
+
+// delaySpread is the width of the range of --delay, set in main.
+var delaySpread time.Duration
+
+// jitterSeed is the seed of the random delays (--jitter-seed), set in main.
+var jitterSeed = uint64(time.Now().UnixNano())
+
+// newJitter returns the random source of the delays for the stream,
+// which differs between the runner and the reloader not to share a source between the goroutines.
+func newJitter(stream uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(jitterSeed, stream))
+}
+
+// randomDelay returns a random duration in [delay, delay+spread].
+func randomDelay(r *rand.Rand, delay, spread time.Duration) time.Duration {
+	if spread <= 0 {
+		return delay
+	}
+	return delay + time.Duration(r.Int64N(int64(spread)+1))
+}
+
+// batchTriggers holds the triggers by the files until n distinct files have changed,
+// or the window has elapsed since the first of them (--min-changed-files).
+// Then it passes the last one and starts the next batch.
+// The other triggers (e.g. --interval) are passed through.
+func batchTriggers(in <-chan trigger, n int, window time.Duration) <-chan trigger {
+	if n <= 1 {
+		return in
 	}
+	out := make(chan trigger)
+	go func() {
+		files := make(map[string]bool)
+		var timeout <-chan time.Time
+		var last trigger
+		for {
+			select {
+			case t := <-in:
+				if t.op == 0 {
+					out <- t
+					continue
+				}
+				files[t.name] = true
+				last = t
+				if len(files) < n {
+					logVerbose("changed %d of %d files: %q", len(files), n, t.name)
+					if timeout == nil && window > 0 {
+						timeout = time.After(window)
+					}
+					continue
+				}
+				log.Printf("[ARELO] %d files changed", len(files))
+			case <-timeout:
+				log.Printf("[ARELO] %d of %d files changed in %v", len(files), n, window)
+			}
+			clear(files)
+			timeout = nil
+			out <- last
+		}
+	}()
+	return out
 }
 
 func clearChBuf[T any](c <-chan T) {
@@ -350,19 +2434,37 @@ func clearChBuf[T any](c <-chan T) {
 	}
 }
 
-func runner(ctx context.Context, wg *sync.WaitGroup, cmd []string, delay time.Duration, sig syscall.Signal, autorestart bool) chan<- string {
-	reload := make(chan string)
-	trigger := make(chan string)
+func runner(ctx context.Context, wg *sync.WaitGroup, cmd []string, delay time.Duration, sig syscall.Signal, autorestart bool) chan<- trigger {
+	reload := make(chan trigger)
+	policy := *burst
+	delayFirst := *delayFirst
+	spread := delaySpread
+	jitter := newJitter(1)
+	lowOps := debounceLowOps
+	minUptime := *minUptime
+	keepAlive := *keepAlive
+	maxRestarts := *maxRestarts
+	crashes := 0 // crashes in a row, accessed by the loop and the command goroutine in turn
+	noRestart := make(map[int]bool)
+	for _, c := range *noRestartCodes {
+		noRestart[c] = true
+	}
+	stdinFile := *stdinFile
+	noStdin := *watchStdin || *confirm // stdin is used by arelo
+	if *noStdinOpt || (!*forceStdin && !isTerminal(os.Stdin)) {
+		noStdin = true
+	}
+	var answers <-chan string
+	if *confirm {
+		answers = readLines(os.Stdin)
+	}
+	hookURL := *hookURL
+	outFile, errFile, outAppend := *outputFile, *stderrFile, *outputAppend
+	onDemand := *onDemand
+	readyFile, readyTimeout := *readyFile, *readyTimeout
+	var lastRestart time.Time // the triggers within the delay after the last (re)start are in the same burst
 
-	go func() {
-		for name := range reload {
-			// ignore restart when the trigger is not waiting
-			select {
-			case trigger <- name:
-			default:
-			}
-		}
-	}()
+	triggerC := collectTriggers(reload, policy, *minChanged, *minWindow)
 
 	var pcmd string // command string for display.
 	for _, s := range cmd {
@@ -374,21 +2476,43 @@ func runner(ctx context.Context, wg *sync.WaitGroup, cmd []string, delay time.Du
 	pcmd = pcmd[1:]
 
 	stdinC := make(chan bytesErr, 1)
-	go func() {
-		b1 := make([]byte, 255)
-		b2 := make([]byte, 255)
-		for {
-			n, err := os.Stdin.Read(b1)
-			stdinC <- bytesErr{b1[:n], err}
-			b1, b2 = b2, b1
-		}
-	}()
+	if stdinFile == "" && !noStdin {
+		go func() {
+			b1 := make([]byte, 255)
+			b2 := make([]byte, 255)
+			for {
+				n, err := os.Stdin.Read(b1)
+				stdinC <- bytesErr{b1[:n], err}
+				b1, b2 = b2, b1
+			}
+		}()
+	}
 
 	chldDone := makeChildDoneChan()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		if onDemand {
+			log.Printf("[ARELO] wait for the first trigger to start: %s", pcmd)
+			session.setState("waiting for the first trigger to start")
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-triggerC:
+				log.Printf("[ARELO] triggered: %q", t.name)
+				session.triggered(t.name)
+				wait := randomDelay(jitter, delay, spread)
+				logVerbose("plan: start %q after %v by %v", pcmd, wait, t)
+				session.setState(fmt.Sprintf("waiting for the delay (%v) to start", wait))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+				lastRestart = time.Now()
+			}
+		}
 		for {
 			select {
 			case <-ctx.Done():
@@ -397,65 +2521,567 @@ func runner(ctx context.Context, wg *sync.WaitGroup, cmd []string, delay time.Du
 			}
 			cmdctx, cancel := context.WithCancel(ctx)
 			restart := make(chan struct{})
+			wait := randomDelay(jitter, delay, spread)
+			started := time.Now()
 			done := make(chan struct{})
+			var readyPrev time.Time
+			if readyFile != "" {
+				readyPrev = modTime(readyFile)
+			}
 
 			go func() {
 				log.Printf("[ARELO] start: %s", pcmd)
-				clearChBuf(chldDone)
-				stdin := &stdinReader{stdinC, chldDone}
-				err := runCmd(cmdctx, cmd, sig, stdin)
+				session.started()
+				stdout, stderr, closeOut, err := openOutputs(outFile, errFile, outAppend)
 				if err != nil {
+					log.Printf("[ARELO] warning: %v", err)
+					stdout, stderr, closeOut = os.Stdout, os.Stderr, func() {}
+				}
+				if stdinFile != "" {
+					err = runCmdWithFile(cmdctx, cmd, sig, stdinFile, stdout, stderr)
+				} else if noStdin {
+					err = runCmd(cmdctx, cmd, sig, nil, stdout, stderr)
+				} else {
+					clearChBuf(chldDone)
+					stdin := &stdinReader{stdinC, chldDone}
+					err = runCmd(cmdctx, cmd, sig, bufio.NewReader(stdin), stdout, stderr)
+				}
+				closeOut()
+				session.exited(errStatus(err))
+				var ee *exec.ExitError
+				if cmdctx.Err() != nil && (err == nil || xerrors.As(err, &ee)) {
+					// stopped by arelo, the exit status by the signal is expected.
+					if ctx.Err() == nil {
+						log.Printf("[ARELO] restarting")
+					} else {
+						log.Printf("[ARELO] command stopped")
+					}
+					logVerbose("stopped by arelo: %s", errStatus(err))
+				} else if err != nil {
 					log.Printf("[ARELO] command error: %v", err)
 				} else {
 					log.Printf("[ARELO] command exit status 0")
 				}
-				if autorestart {
+				postHook(hookURL, hookEvent{Event: "exit", Command: pcmd, Status: errStatus(err), Time: time.Now()})
+				crashed := err != nil && cmdctx.Err() == nil
+				if crashed {
+					session.crashed()
+					if time.Since(started) >= crashLoopUptime {
+						crashes = 0
+					}
+					crashes++
+				} else {
+					crashes = 0
+				}
+				if (autorestart || keepAlive) && xerrors.As(err, &ee) && noRestart[ee.ExitCode()] {
+					log.Printf("[ARELO] restart suppressed by exit code %d, wait for the next trigger", ee.ExitCode())
+				} else if (autorestart || keepAlive && crashed) && maxRestarts > 0 && crashes > maxRestarts {
+					log.Printf("[ARELO] command crashed %d times in a row, auto restart paused until the next trigger", crashes)
+					session.setPaused(crashes)
+				} else if autorestart {
+					close(restart)
+				} else if keepAlive && crashed {
+					// exited unexpectedly, not by arelo.
 					close(restart)
 				}
 
 				close(done)
 			}()
 
-			select {
-			case <-ctx.Done():
+			if readyFile != "" {
+				session.setState(fmt.Sprintf("waiting for the ready file %q", readyFile))
+				if err := waitReady(ctx, readyFile, readyPrev, readyTimeout, done); err == nil {
+					log.Printf("[ARELO] ready: %q", readyFile)
+				} else if ctx.Err() == nil {
+					log.Printf("[ARELO] warning: ready file %q: %v", readyFile, err)
+				}
+			}
+
+			byTrigger := false
+			session.setState("waiting for a trigger")
+			for {
+				select {
+				case <-ctx.Done():
+					cancel()
+					<-done
+					return
+				case t := <-triggerC:
+					log.Printf("[ARELO] triggered: %q", t.name)
+					session.triggered(t.name)
+					if up := time.Since(started); up < minUptime {
+						log.Printf("[ARELO] queued until min uptime (%v): %q", minUptime, t.name)
+						session.setState(fmt.Sprintf("holding the trigger until the min uptime (%v)", minUptime))
+						select {
+						case <-ctx.Done():
+							cancel()
+							<-done
+							return
+						case <-time.After(minUptime - up):
+						}
+						log.Printf("[ARELO] apply queued trigger: %q", t.name)
+					}
+					if answers != nil {
+						session.setState("waiting for the confirmation of the restart")
+						if !confirmRestart(ctx, answers, os.Stderr, pcmd, t) {
+							log.Printf("[ARELO] restart declined, wait for the next trigger")
+							session.setState("waiting for a trigger")
+							continue
+						}
+					}
+					byTrigger = true
+					if delayFirst && time.Since(lastRestart) < delay {
+						// not the first restart of the burst.
+						wait = 0
+					}
+					logVerbose("plan: restart %q after %v by %v", pcmd, wait, t)
+					postHook(hookURL, hookEvent{Event: "restart", Command: pcmd, Trigger: t.name, Pattern: t.pattern, Time: time.Now()})
+				case <-restart:
+					logVerbose("auto restart")
+					postHook(hookURL, hookEvent{Event: "restart", Command: pcmd, Time: time.Now()})
+				}
+				break
+			}
+
+			logVerbose("wait %v", wait)
+			session.setState(fmt.Sprintf("waiting for the delay (%v) to restart", wait))
+			var debounce <-chan trigger // receive triggers during the delay on "debounce" policy
+			if policy == "debounce" && wait > 0 {
+				debounce = triggerC
+			}
+			if !waitDelay(ctx, wait, debounce, lowOps) {
 				cancel()
 				<-done
 				return
-			case name := <-trigger:
-				log.Printf("[ARELO] triggered: %q", name)
-			case <-restart:
-				logVerbose("auto restart")
 			}
+			session.setState("stopping the command")
+			cancel()
+			<-done // wait process closed
+			lastRestart = time.Now()
+			if byTrigger && crashes > 0 {
+				if maxRestarts > 0 && crashes > maxRestarts {
+					log.Printf("[ARELO] restart counter reset")
+					session.setPaused(0)
+				}
+				crashes = 0
+			}
+		}
+	}()
+
+	return reload
+}
+
+// isTerminal reports whether the file is a terminal.
+// The character devices other than the null device are regarded as terminals.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(fi, null) {
+		return false
+	}
+	return true
+}
+
+// modTime returns the modification time of the file, or zero time if it does not exist.
+func modTime(file string) time.Time {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// waitReady waits for the file to be created or updated from the prev modification time (--ready-file).
+// It gives up when the timeout (if not 0) expires, or the command exits.
+func waitReady(ctx context.Context, file string, prev time.Time, timeout time.Duration, exited <-chan struct{}) error {
+	tick := time.NewTicker(readyPoll)
+	defer tick.Stop()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		if t := modTime(file); !t.IsZero() && !t.Equal(prev) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-exited:
+			return xerrors.New("command exited before ready")
+		case <-expired:
+			return xerrors.Errorf("not ready in %v", timeout)
+		case <-tick.C:
+		}
+	}
+}
+
+// readLines sends the lines read from r to the returned chan, and closes it on EOF.
+func readLines(r io.Reader) <-chan string {
+	c := make(chan string)
+	go func() {
+		defer close(c)
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			c <- s.Text()
+		}
+	}()
+	return c
+}
+
+// confirmRestart asks on the terminal whether to restart the command by the trigger (--restart-confirm).
+// It answers no on EOF or timeout.
+func confirmRestart(ctx context.Context, answers <-chan string, w io.Writer, pcmd string, t trigger) bool {
+	clearChBuf(answers) // discard the lines typed before the prompt
+	fmt.Fprintf(w, "[ARELO] restart %q by %v? [y/N] ", pcmd, t)
+	timer := time.NewTimer(confirmTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(w)
+		return false
+	case <-timer.C:
+		fmt.Fprintln(w)
+		log.Printf("[ARELO] no answer in %v", confirmTimeout)
+		return false
+	case a, ok := <-answers:
+		if !ok {
+			fmt.Fprintln(w)
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(a)) {
+		case "y", "yes":
+			return true
+		}
+		return false
+	}
+}
+
+// collectTriggers collects the triggers from all the sources through --min-changed-files,
+// and passes each of them only when the returned channel is being received (waiting for a trigger).
+// The triggers while not waiting are dropped, or the last of them is queued on "queue" policy.
+func collectTriggers(in <-chan trigger, policy string, minChanged int, window time.Duration) <-chan trigger {
+	triggerC := make(chan trigger)
+	batched := batchTriggers(in, minChanged, window)
+	go func() {
+		var pending *trigger // queued trigger on "queue" policy
+		for {
+			if pending == nil {
+				t := <-batched
+				// ignore restart when the trigger is not waiting
+				select {
+				case triggerC <- t:
+				default:
+					if policy == "queue" {
+						logVerbose("queued: %q", t.name)
+						pending = &t
+					}
+				}
+				continue
+			}
+			select {
+			case t := <-batched:
+				pending = &t
+			case triggerC <- *pending:
+				pending = nil
+			}
+		}
+	}()
+	return triggerC
+}
+
+// waitDelay waits for the delay.
+// The triggers from debounce (nil unless "debounce" policy) extend the delay,
+// except the low priority file events (--debounce-low-ops).
+// It returns false if ctx is done.
+func waitDelay(ctx context.Context, wait time.Duration, debounce <-chan trigger, lowOps fsnotify.Op) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case t := <-debounce:
+			if t.op != 0 && !isWatchedOp(t.op, lowOps) {
+				// e.g. the chmod by the editor after the save.
+				logVerbose("not debounced by low priority %v: %q", opString(t.op), t.name)
+				continue
+			}
+			logVerbose("debounced: %q", t.name)
+			timer.Reset(wait)
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// reloader runs the reload command by the shell (or the --interpreter) on each trigger.
+// The triggers are collected like the runner: --min-changed-files, --burst-policy and --debounce-low-ops are applied.
+func reloader(ctx context.Context, wg *sync.WaitGroup, rcmd string, delay time.Duration, sig syscall.Signal) chan<- trigger {
+	reload := make(chan trigger)
+	policy := *burst
+	lowOps := debounceLowOps
+	triggerC := collectTriggers(reload, policy, *minChanged, *minWindow)
+	rargs := shellCommand(rcmd)
+	spread := delaySpread
+	jitter := newJitter(2)
+	if *interpreter != "" {
+		// validated in main
+		rargs, _ = interpreterCommand(*interpreter, rcmd)
+	}
 
-			logVerbose("wait %v", delay)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
 			select {
 			case <-ctx.Done():
-				cancel()
-				<-done
 				return
-			case <-time.After(delay):
+			case t := <-triggerC:
+				log.Printf("[ARELO] triggered: %q", t.name)
+				wait := randomDelay(jitter, delay, spread)
+				logVerbose("plan: reload %q after %v by %v", rcmd, wait, t)
+				var debounce <-chan trigger // receive triggers during the delay on "debounce" policy
+				if policy == "debounce" && wait > 0 {
+					debounce = triggerC
+				}
+				if !waitDelay(ctx, wait, debounce, lowOps) {
+					return
+				}
+			}
+
+			log.Printf("[ARELO] reload: %s", rcmd)
+			if err := runReloadCmd(ctx, rargs, sig); err != nil {
+				log.Printf("[ARELO] reload command error: %v", err)
+			} else {
+				log.Printf("[ARELO] reload command exit status 0")
 			}
-			cancel()
-			<-done // wait process closed
 		}
 	}()
 
 	return reload
 }
 
-func runCmd(ctx context.Context, cmd []string, sig syscall.Signal, stdin *stdinReader) error {
+// stopCmd stops the command by the sig, and by SIGKILL if it is not stopped in --kill-timeout-per-signal.
+// It gives up waiting for the done in --kill-timeout after SIGKILL,
+// so that arelo is not hung up by the process in uninterruptible sleep.
+func stopCmd(c *exec.Cmd, sig syscall.Signal, done <-chan struct{}) error {
+	pid := c.Process.Pid
+	logVerbose("stopping pid=%d by %s", pid, signalName(sig))
+	if err := killChilds(c, sig); err != nil {
+		return xerrors.Errorf("kill childs: %w", err)
+	}
+	select {
+	case <-done:
+		return nil
+	case <-time.After(*sigTimeout):
+	}
+	logVerbose("pid=%d did not stop in %v, sending SIGKILL", pid, *sigTimeout)
+	if err := killChilds(c, syscall.SIGKILL); err != nil {
+		return xerrors.Errorf("kill childs (SIGKILL): %w", err)
+	}
+	if err := waitKilled(done, *killTimeout); err != nil {
+		log.Printf("[ARELO] warning: pid=%d is unkillable, give up waiting, it may be left running (e.g. in uninterruptible sleep)", pid)
+		return xerrors.Errorf("pid=%d: %w", pid, err)
+	}
+	return nil
+}
+
+// waitKilled waits for the done after SIGKILL at most the timeout (0: forever).
+func waitKilled(done <-chan struct{}, timeout time.Duration) error {
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timeoutC = time.After(timeout)
+	}
+	select {
+	case <-done:
+		return nil
+	case <-timeoutC:
+		return xerrors.Errorf("not stopped in %v after SIGKILL", timeout)
+	}
+}
+
+// runReloadCmd runs the reload command until it exits.
+// The command is stopped when ctx is done.
+func runReloadCmd(ctx context.Context, cmd []string, sig syscall.Signal) error {
 	c := prepareCommand(cmd)
-	c.Stdin = bufio.NewReader(stdin)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	if err := c.Start(); err != nil {
 		return err
 	}
+	var cerr error
+	done := make(chan struct{})
+	go func() {
+		cerr = c.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return cerr
+	case <-ctx.Done():
+		if err := stopCmd(c, sig, done); err != nil {
+			return err
+		}
+		return cerr
+	}
+}
+
+// forwardTriggers forwards the triggers of the watcher to reload until ctx is done.
+// It returns the error when the watcher fails or is closed.
+func forwardTriggers(ctx context.Context, modC <-chan trigger, errC <-chan error, reload chan<- trigger) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case t, ok := <-modC:
+			if !ok {
+				return xerrors.New("wacher closed")
+			}
+			select {
+			case reload <- t:
+			case <-ctx.Done():
+				return nil
+			}
+		case err := <-errC:
+			return xerrors.Errorf("wacher error: %w", err)
+		}
+	}
+}
+
+// scheduler triggers the scheduled run on every interval.
+// The triggers go through the same delay and burst policy as the modifications.
+func scheduler(ctx context.Context, interval time.Duration, reload chan<- trigger) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			log.Printf("[ARELO] scheduled run (every %v)", interval)
+			select {
+			case reload <- trigger{name: "scheduled run"}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// stdinCommands reads the commands from r line by line.
+//
+//	restart [file]  restart the COMMAND (triggered by the file if given)
+//	reload [file]   run the reload command, or restart the COMMAND if it is not given
+//	quit            stop the COMMAND and exit arelo
+func stdinCommands(r io.Reader, restartC, reloadC chan<- trigger, quit chan<- struct{}) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(sc.Text()), " ")
+		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			arg = "stdin"
+		}
+		switch cmd {
+		case "":
+		case "restart":
+			if restartC == nil {
+				log.Printf("[ARELO] stdin: no COMMAND to restart")
+				continue
+			}
+			restartC <- trigger{name: arg}
+		case "reload":
+			reloadC <- trigger{name: arg}
+		case "quit":
+			close(quit)
+			return
+		default:
+			log.Printf("[ARELO] stdin: unknown command: %q", cmd)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		log.Printf("[ARELO] stdin: %v", err)
+	}
+}
+
+// exitStatus returns the exit code or the signal which terminated the process.
+func exitStatus(ps *os.ProcessState) string {
+	if ps == nil {
+		return "code=unknown"
+	}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return "signal=" + signalName(ws.Signal())
+	}
+	return fmt.Sprintf("code=%d", ps.ExitCode())
+}
+
+// runCmdWithFile runs the command with the file as its stdin.
+// The file is opened on each run to feed the current content.
+func runCmdWithFile(ctx context.Context, cmd []string, sig syscall.Signal, file string, stdout, stderr io.Writer) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return xerrors.Errorf("stdin file: %w", err)
+	}
+	defer f.Close()
+	return runCmd(ctx, cmd, sig, f, stdout, stderr)
+}
+
+// startCmd starts the command.
+// It retries when the executable is busy (ETXTBSY),
+// e.g. the binary has just been rebuilt and the build tool has not closed it yet.
+func startCmd(ctx context.Context, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (*exec.Cmd, error) {
+	wait := textBusyWait
+	for i := 0; ; i++ {
+		c := prepareCommand(cmd)
+		c.Stdin = stdin
+		c.Stdout = stdout
+		c.Stderr = stderr
+		// the writers other than *os.File (e.g. --output-file) are connected by the pipes,
+		// which a daemon spawned by the command may keep open.
+		c.WaitDelay = outputWaitDelay
+		err := c.Start()
+		if err == nil || !xerrors.Is(err, syscall.ETXTBSY) || i >= textBusyRetries {
+			return c, err
+		}
+		logVerbose("text file busy, retry in %v: %v", wait, err)
+		select {
+		case <-ctx.Done():
+			return c, err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func runCmd(ctx context.Context, cmd []string, sig syscall.Signal, stdin io.Reader, stdout, stderr io.Writer) error {
+	c, err := startCmd(ctx, cmd, stdin, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	pid := c.Process.Pid
+	logVerbose("started pid=%d", pid)
+	if *nice != 0 {
+		if err := setPriority(c, *nice); err != nil {
+			log.Printf("[ARELO] warning: cannot set nice %d: %v", *nice, err)
+		}
+	}
+	if err := setCPUAffinity(pid); err != nil {
+		log.Printf("[ARELO] warning: cannot set cpu affinity: %v", err)
+	}
 
 	var cerr error
 	done := make(chan struct{})
 	go func() {
 		cerr = waitCmd(c)
+		if xerrors.Is(cerr, exec.ErrWaitDelay) {
+			logVerbose("the output of pid=%d is still held by its children", pid)
+			cerr = nil
+		}
+		logVerbose("exited pid=%d %s", pid, exitStatus(c.ProcessState))
 		close(done)
 	}()
 
@@ -466,19 +3092,13 @@ func runCmd(ctx context.Context, cmd []string, sig syscall.Signal, stdin *stdinR
 		}
 		return cerr
 	case <-ctx.Done():
-		if err := killChilds(c, sig); err != nil {
-			return xerrors.Errorf("kill childs: %w", err)
-		}
 	}
 
-	select {
-	case <-done:
-	case <-time.After(waitForTerm):
-		if err := killChilds(c, syscall.SIGKILL); err != nil {
-			return xerrors.Errorf("kill childs (SIGKILL): %w", err)
-		}
-		<-done
+	stopping := time.Now()
+	if err := stopCmd(c, sig, done); err != nil {
+		return err
 	}
+	logVerbose("stopped pid=%d in %v", pid, time.Since(stopping))
 
 	if cerr != nil {
 		return xerrors.Errorf("process canceled: %w", cerr)
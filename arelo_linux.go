@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+const maxCPUs = 1024 // CPU_SETSIZE
+
+// cpuset to run the command on, set by setupCPUAffinity.
+var cpuset *unix.CPUSet
+
+// setupCPUAffinity parses the CPU list such as "0,1" or "0-3,6".
+func setupCPUAffinity(str string) error {
+	if str == "" {
+		return nil
+	}
+	set, err := parseCPUList(str)
+	if err != nil {
+		return err
+	}
+	cpuset = set
+	return nil
+}
+
+func parseCPUList(str string) (*unix.CPUSet, error) {
+	var set unix.CPUSet
+	for _, s := range strings.Split(str, ",") {
+		lo, hi, rng := strings.Cut(strings.TrimSpace(s), "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid cpu affinity %q: %w", str, err)
+		}
+		last := first
+		if rng {
+			last, err = strconv.Atoi(hi)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid cpu affinity %q: %w", str, err)
+			}
+		}
+		if first < 0 || last < first || last >= maxCPUs {
+			return nil, xerrors.Errorf("invalid cpu affinity %q: out of range: %q", str, s)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			set.Set(cpu)
+		}
+	}
+	return &set, nil
+}
+
+// setCPUAffinity pins the command to the cpuset.
+// The processes spawned by the command after that inherit it.
+func setCPUAffinity(pid int) error {
+	if cpuset == nil {
+		return nil
+	}
+	return unix.SchedSetaffinity(pid, cpuset)
+}
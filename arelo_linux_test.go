@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		in   string
+		cpus []int
+	}{
+		{"0", []int{0}},
+		{"0,1", []int{0, 1}},
+		{"0-3,6", []int{0, 1, 2, 3, 6}},
+		{" 2 , 4-5", []int{2, 4, 5}},
+	}
+	for _, test := range tests {
+		set, err := parseCPUList(test.in)
+		if err != nil {
+			t.Fatalf("%q: %v", test.in, err)
+		}
+		if set.Count() != len(test.cpus) {
+			t.Fatalf("%q: count = %v, wants %v", test.in, set.Count(), len(test.cpus))
+		}
+		for _, c := range test.cpus {
+			if !set.IsSet(c) {
+				t.Fatalf("%q: cpu %v is not set", test.in, c)
+			}
+		}
+	}
+
+	for _, in := range []string{"", "a", "1-", "3-1", "-1", "1,,2", "99999"} {
+		if _, err := parseCPUList(in); err == nil {
+			t.Fatalf("%q: must be error", in)
+		}
+	}
+}
+
+func TestWatcherFanotify(t *testing.T) {
+	tmpdir := t.TempDir()
+	w, _, _, err := newFanotifyWatcher(false)
+	if err != nil {
+		t.Skipf("fanotify is not available: %v", err)
+	}
+	err = w.Add(tmpdir)
+	w.Close()
+	if err != nil {
+		t.Skipf("fanotify is not available: %v", err)
+	}
+
+	*watchMode = "fanotify"
+	t.Cleanup(func() { *watchMode = "fsnotify" })
+
+	target := path.Join(tmpdir, "target")
+	other := path.Join(tmpdir, "other")
+	for _, d := range []string{target, other} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("Mkdir: %v", err)
+		}
+	}
+	modC, errC, err := watcher([]string{target}, []string{"**/file*"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	newdir := path.Join(target, "new")
+	tests := []struct {
+		op     func()
+		file   string
+		detect bool
+	}{
+		{func() { touchFile(path.Join(target, "file1")) }, path.Join(target, "file1"), true},
+		{func() { touchFile(path.Join(other, "file2")) }, "", false},
+		{func() { os.Mkdir(newdir, 0755) }, "", false},
+		{func() { touchFile(path.Join(newdir, "file3")) }, path.Join(newdir, "file3"), true},
+		{func() { os.Remove(path.Join(target, "file1")) }, path.Join(target, "file1"), true},
+	}
+	for i, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		test.op()
+		select {
+		case f := <-modC:
+			if !test.detect {
+				t.Fatalf("%v: must not be detect: %q", i, f.name)
+			}
+			if f.name != test.file {
+				t.Fatalf("%v: unexpected file modified: %q, wants %q", i, f.name, test.file)
+			}
+		case e := <-errC:
+			t.Fatalf("%v: watcher error: %v", i, e)
+		case <-time.After(time.Second / 2):
+			if test.detect {
+				t.Fatalf("%v: must be detect: %q", i, test.file)
+			}
+		}
+	}
+}
+
+func TestFanotifyReadError(t *testing.T) {
+	r, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer pw.Close()
+	w := &fanotifyWatcher{
+		f:      r,
+		events: make(chan fsnotify.Event),
+		errors: make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+	go w.readEvents()
+
+	// the overflow is reported as an error, which nobody receives.
+	b := make([]byte, sizeofFanotifyEventMetadata)
+	binary.NativeEndian.PutUint32(b[0:], uint32(len(b)))
+	binary.NativeEndian.PutUint64(b[8:], unix.FAN_Q_OVERFLOW)
+	if _, err := pw.Write(b); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	select {
+	case _, ok := <-w.events:
+		if ok {
+			t.Fatalf("unexpected event")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the reader must return without the receiver of the error")
+	}
+	if err := <-w.errors; !xerrors.Is(err, fsnotify.ErrEventOverflow) {
+		t.Fatalf("error = %v, wants %v", err, fsnotify.ErrEventOverflow)
+	}
+}
+
+func TestWatcherFilterBackends(t *testing.T) {
+	modes := []string{"fsnotify"}
+	if w, _, _, err := newFanotifyWatcher(false); err == nil {
+		err = w.Add(t.TempDir())
+		w.Close()
+		if err == nil {
+			modes = append(modes, "fanotify")
+		}
+	}
+	t.Cleanup(func() { *watchMode = "fsnotify" })
+
+	tests := []struct {
+		filter string
+		op     func(file string) error
+	}{
+		{"CREATE", func(file string) error {
+			f, err := os.Create(file + "new")
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{"WRITE", func(file string) error {
+			f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+			if err == nil {
+				_, err = f.WriteString("b")
+				f.Close()
+			}
+			return err
+		}},
+		{"REMOVE", func(file string) error { return os.Remove(file) }},
+		{"RENAME", func(file string) error { return os.Rename(file, path.Join(path.Dir(file), "other")) }},
+		{"CHMOD", func(file string) error { return os.Chmod(file, 0600) }},
+	}
+	for _, mode := range modes {
+		*watchMode = mode
+		for _, test := range tests {
+			filtOp, err := parseFilters([]string{test.filter})
+			if err != nil {
+				t.Fatalf("parseFilters: %v", err)
+			}
+			tmpdir := t.TempDir()
+			file := path.Join(tmpdir, "file")
+			touchFile(file)
+			modC, errC, err := watcher([]string{tmpdir}, []string{"**/file*"}, nil, filtOp)
+			if err != nil {
+				t.Fatalf("%s: watcher: %v", mode, err)
+			}
+
+			// the filtered operation is not detected.
+			<-time.After(time.Second / 5)
+			clearChan(modC, errC)
+			if err := test.op(file); err != nil {
+				t.Fatalf("%s %s: %v", mode, test.filter, err)
+			}
+			select {
+			case f := <-modC:
+				t.Fatalf("%s: %s must be filtered: %v %q", mode, test.filter, f.op, f.name)
+			case e := <-errC:
+				t.Fatalf("%s: watcher error: %v", mode, e)
+			case <-time.After(time.Second / 3):
+			}
+
+			// the other operations are detected.
+			other := path.Join(tmpdir, "file2")
+			touchFile(other)
+			select {
+			case f := <-modC:
+				if f.name != other {
+					t.Fatalf("%s %s: unexpected file modified: %q, wants %q", mode, test.filter, f.name, other)
+				}
+			case e := <-errC:
+				t.Fatalf("%s: watcher error: %v", mode, e)
+			case <-time.After(time.Second):
+				t.Fatalf("%s %s: must be detect: %q", mode, test.filter, other)
+			}
+		}
+	}
+}
+
+func TestWatcherFanotifyAccess(t *testing.T) {
+	tmpdir := t.TempDir()
+	w, _, _, err := newFanotifyWatcher(true)
+	if err != nil {
+		t.Skipf("fanotify is not available: %v", err)
+	}
+	err = w.Add(tmpdir)
+	w.Close()
+	if err != nil {
+		t.Skipf("fanotify is not available: %v", err)
+	}
+
+	*watchMode = "fanotify"
+	*onAccess = true
+	t.Cleanup(func() {
+		*watchMode = "fsnotify"
+		*onAccess = false
+	})
+
+	file := path.Join(tmpdir, "file")
+	os.WriteFile(file, []byte("a"), 0644)
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**/file"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		read   func()
+		detect bool
+	}{
+		{func() { os.ReadFile(file) }, false}, // by arelo itself
+		{func() { exec.Command("cat", file).Run() }, true},
+	}
+	for i, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		test.read()
+		select {
+		case f := <-modC:
+			if !test.detect {
+				t.Fatalf("%v: must not be detect: %v", i, f)
+			}
+			if f.name != file || !f.op.Has(opAccess) {
+				t.Fatalf("%v: unexpected trigger: %v, wants ACCESS %q", i, f, file)
+			}
+		case e := <-errC:
+			t.Fatalf("%v: watcher error: %v", i, e)
+		case <-time.After(time.Second / 2):
+			if test.detect {
+				t.Fatalf("%v: must be detect: %q", i, file)
+			}
+		}
+	}
+}
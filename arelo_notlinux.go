@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/xerrors"
+)
+
+func setupCPUAffinity(str string) error {
+	if str == "" {
+		return nil
+	}
+	return xerrors.New("CPU affinity option (--cpu-affinity) is only available on Linux.")
+}
+
+func setCPUAffinity(pid int) error {
+	return nil
+}
+
+func newFanotifyWatcher(access bool) (fsWatcher, <-chan fsnotify.Event, <-chan error, error) {
+	return nil, nil, nil, xerrors.New("fanotify is only available on Linux")
+}
@@ -1,10 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
 )
 
 func TestWatcher(t *testing.T) {
@@ -54,11 +69,11 @@ func TestWatcher(t *testing.T) {
 		touchFile(test.file)
 		select {
 		case f := <-modC:
-			if f != test.file {
-				t.Fatalf("unexpected file modified: %q, wants %q", f, test.file)
+			if f.name != test.file {
+				t.Fatalf("unexpected file modified: %q, wants %q", f.name, test.file)
 			}
 			if !test.detect {
-				t.Fatalf("must not be detect: %q", f)
+				t.Fatalf("must not be detect: %q", f.name)
 			}
 		case e := <-errC:
 			t.Fatalf("watcher error: %v", e)
@@ -70,41 +85,1925 @@ func TestWatcher(t *testing.T) {
 	}
 }
 
-func clearChan(c <-chan string, ce <-chan error) {
-	for {
+func TestTargetPatterns(t *testing.T) {
+	args := []optArg{
+		{"pattern", "**/*.md"},
+		{"target", "./api"},
+		{"pattern", "**/*.go"},
+		{"pattern", "**/go.mod"},
+		{"target", "web/"},
+		{"pattern", "**/*.js"},
+		{"target", "doc"},
+	}
+	tps := targetPatterns(args)
+
+	tests := []struct {
+		file string
+		pats []string
+	}{
+		{"api/main.go", []string{"**/*.md", "**/*.go", "**/go.mod"}},
+		{"./web/sub/main.js", []string{"**/*.md", "**/*.js"}},
+		{"doc/index.md", []string{"**/*.md"}},
+		{"other/file", nil},
+	}
+	for _, test := range tests {
+		pats := patternsFor(test.file, tps)
+		if !reflect.DeepEqual(pats, test.pats) {
+			t.Fatalf("patternsFor(%q) = %q, wants %q", test.file, pats, test.pats)
+		}
+	}
+
+	tps = targetPatterns([]optArg{{"target", "a"}})
+	if pats := patternsFor("a/b", tps); !reflect.DeepEqual(pats, []string{"**"}) {
+		t.Fatalf("patternsFor(%q) = %q, wants %q", "a/b", pats, []string{"**"})
+	}
+}
+
+func TestWatcherStrictTargetPatterns(t *testing.T) {
+	tmpdir := t.TempDir()
+	api := path.Join(tmpdir, "api")
+	web := path.Join(tmpdir, "web")
+	for _, d := range []string{api, web} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+	}
+
+	targetPats = targetPatterns([]optArg{
+		{"target", api}, {"pattern", "**/*.go"},
+		{"target", web}, {"pattern", "**/*.js"},
+	})
+	t.Cleanup(func() { targetPats = nil })
+
+	modC, errC, err := watcher([]string{api, web}, []string{"**/*.go", "**/*.js"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		file   string
+		detect bool
+	}{
+		{path.Join(api, "main.go"), true},
+		{path.Join(api, "main.js"), false},
+		{path.Join(web, "main.js"), true},
+		{path.Join(web, "main.go"), false},
+	}
+	for _, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		touchFile(test.file)
 		select {
-		case <-c:
-		case <-ce:
-		default:
-			return
+		case f := <-modC:
+			if f.name != test.file {
+				t.Fatalf("unexpected file modified: %q, wants %q", f.name, test.file)
+			}
+			if !test.detect {
+				t.Fatalf("must not be detect: %q", f.name)
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect: %q", test.file)
+			}
 		}
 	}
 }
 
-func touchFile(file string) {
-	os.WriteFile(file, []byte("a"), 0644)
+func TestWatcherFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		op     func(file string)
+		detect bool
+	}{
+		{"CHMOD", func(f string) { os.Chmod(f, 0600) }, false},
+		{"CHMOD", func(f string) { touchFile(f) }, true},
+		{"WRITE", func(f string) { touchFile(f) }, false},
+		{"WRITE", func(f string) { os.Chmod(f, 0600) }, true},
+		{"REMOVE", func(f string) { os.Remove(f) }, false},
+		{"REMOVE", func(f string) { os.Chmod(f, 0600) }, true},
+		{"RENAME", func(f string) { os.Rename(f, f+".bak") }, false},
+		{"RENAME", func(f string) { os.Remove(f) }, true},
+	}
+	for _, test := range tests {
+		tmpdir := t.TempDir()
+		file := path.Join(tmpdir, "file")
+		touchFile(file)
+
+		filtOp, err := parseFilters([]string{test.filter})
+		if err != nil {
+			t.Fatalf("parseFilters: %v", err)
+		}
+		modC, errC, err := watcher([]string{tmpdir}, []string{"**/file"}, nil, filtOp)
+		if err != nil {
+			t.Fatalf("watcher: %v", err)
+		}
+
+		test.op(file)
+		select {
+		case f := <-modC:
+			if !test.detect {
+				t.Fatalf("must not be detect (filter=%v): %v", test.filter, f)
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect (filter=%v): %q", test.filter, file)
+			}
+		}
+	}
 }
 
-func TestMatchPatterns(t *testing.T) {
+func TestIsWatchedOp(t *testing.T) {
 	tests := []struct {
-		t, pat string
-		wants  bool
+		op, filter fsnotify.Op
+		wants      bool
 	}{
-		{"ab/cd/efg", "**/efg", true},
-		{"ab/cd/efg", "*/efg", false},
-		{"./abc.efg", "**/*.efg", true},
-		{"./abc.efg", "*.efg", true},
-		{"./.abc", "**/.*", true},
-		{"./.abc", ".*", true},
+		{fsnotify.Write, 0, true},
+		{fsnotify.Chmod, fsnotify.Chmod, false},
+		{fsnotify.Write, fsnotify.Chmod, true},
+		{fsnotify.Write | fsnotify.Chmod, fsnotify.Chmod, true},
+		{fsnotify.Write | fsnotify.Chmod, fsnotify.Write, true},
+		{fsnotify.Write | fsnotify.Chmod, fsnotify.Write | fsnotify.Chmod, false},
+		{fsnotify.Create | fsnotify.Write, fsnotify.Create | fsnotify.Chmod, true},
+		{fsnotify.Remove | fsnotify.Rename, fsnotify.Remove | fsnotify.Rename, false},
+	}
+	for _, test := range tests {
+		if r := isWatchedOp(test.op, test.filter); r != test.wants {
+			t.Fatalf("isWatchedOp(%v, %v) = %v, wants %v", test.op, test.filter, r, test.wants)
+		}
+	}
+}
+
+func TestWatcherNoExistingTriggers(t *testing.T) {
+	*noExisting = true
+	t.Cleanup(func() { *noExisting = false })
+
+	tmpdir := t.TempDir()
+	if err := os.MkdirAll(path.Join(tmpdir, "target"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.MkdirAll(path.Join(tmpdir, "mv", "mvsub"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	touchFile(path.Join(tmpdir, "mv", "file"))
+	touchFile(path.Join(tmpdir, "mv", "mvsub", "file"))
+
+	modC, errC, err := watcher([]string{path.Join(tmpdir, "target")}, []string{"**/file"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	// the existing files in the moved directory do not trigger.
+	if err := os.Rename(path.Join(tmpdir, "mv"), path.Join(tmpdir, "target", "mv")); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	select {
+	case f := <-modC:
+		t.Fatalf("must not be detect: %q", f.name)
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(time.Second / 5):
+	}
+
+	// the modification after that triggers.
+	file := path.Join(tmpdir, "target", "mv", "mvsub", "file")
+	touchFile(file)
+	select {
+	case f := <-modC:
+		if f.name != file {
+			t.Fatalf("unexpected file modified: %q, wants %q", f.name, file)
+		}
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(time.Second / 5):
+		t.Fatalf("must be detect: %q", file)
+	}
+}
+
+func TestWatcherContentMatch(t *testing.T) {
+	crs, err := parseContentRules([]string{"**/ready=(?m)^READY$"})
+	if err != nil {
+		t.Fatalf("parseContentRules: %v", err)
+	}
+	contentRules = crs
+	t.Cleanup(func() { contentRules = nil })
+
+	tmpdir := t.TempDir()
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**/ready", "**/file"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
 	}
 
+	tests := []struct {
+		file    string
+		content string
+		detect  bool
+	}{
+		{path.Join(tmpdir, "ready"), "building\n", false},
+		{path.Join(tmpdir, "ready"), "building\nREADY\n", true},
+		{path.Join(tmpdir, "file"), "building\n", true}, // no rule for the file
+	}
 	for _, test := range tests {
-		r, err := matchPatterns(test.t, []string{test.pat})
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		os.WriteFile(test.file, []byte(test.content), 0644)
+		select {
+		case f := <-modC:
+			if f.name != test.file {
+				t.Fatalf("unexpected file modified: %q, wants %q", f.name, test.file)
+			}
+			if !test.detect {
+				t.Fatalf("must not be detect: %q %q", f.name, test.content)
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect: %q %q", test.file, test.content)
+			}
+		}
+	}
+}
+
+func TestParseContentRules(t *testing.T) {
+	for _, r := range []string{"**/log", "=READY", "**/log=(", "[=READY"} {
+		if _, err := parseContentRules([]string{r}); err == nil {
+			t.Fatalf("parseContentRules(%q) must be error", r)
+		}
+	}
+	crs, err := parseContentRules([]string{"**/log=a=b"})
+	if err != nil {
+		t.Fatalf("parseContentRules: %v", err)
+	}
+	if crs[0].glob != "**/log" || crs[0].re.String() != "a=b" {
+		t.Fatalf("parseContentRules: %q %q", crs[0].glob, crs[0].re)
+	}
+}
+
+func TestWatcherSizeTrigger(t *testing.T) {
+	srs, err := parseSizeRules([]string{"**/log:>4", "**/log:<2"})
+	if err != nil {
+		t.Fatalf("parseSizeRules: %v", err)
+	}
+	sizeRules = srs
+	t.Cleanup(func() { sizeRules = nil })
+
+	tmpdir := t.TempDir()
+	file := path.Join(tmpdir, "log")
+	os.WriteFile(file, []byte("aaa"), 0644)
+
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		size   int64
+		detect bool
+	}{
+		{4, false},
+		{5, true}, // grows past 4
+		{6, false},
+		{1, true}, // shrinks below 2
+		{0, false},
+	}
+	for _, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		os.Truncate(file, test.size)
+		select {
+		case f := <-modC:
+			if !test.detect {
+				t.Fatalf("must not be detect: %q %v", f.name, test.size)
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect: %q %v", file, test.size)
+			}
+		}
+	}
+}
+
+func TestParseSizeRules(t *testing.T) {
+	tests := []struct {
+		rule  string
+		wants sizeRule
+	}{
+		{"**/*.log:>1MB", sizeRule{"**/*.log", ">", 1 << 20}},
+		{"a:b:<= 10k", sizeRule{"a:b", "<=", 10 << 10}},
+		{"log:>=2GiB", sizeRule{"log", ">=", 2 << 30}},
+		{"log:<100", sizeRule{"log", "<", 100}},
+	}
+	for _, test := range tests {
+		srs, err := parseSizeRules([]string{test.rule})
 		if err != nil {
-			t.Fatalf("matchPatterns(%v, {%v}): %v", test.t, test.pat, err)
+			t.Fatalf("parseSizeRules(%q): %v", test.rule, err)
 		}
-		if r != test.wants {
-			t.Fatalf("matchPatterns(%v, {%v}) = %v wants %v", test.t, test.pat, r, test.wants)
+		if srs[0] != test.wants {
+			t.Fatalf("parseSizeRules(%q) = %v, wants %v", test.rule, srs[0], test.wants)
+		}
+	}
+	for _, r := range []string{"log", ":>1", "log:1MB", "log:>1XB", "log:=1", "[:>1"} {
+		if _, err := parseSizeRules([]string{r}); err == nil {
+			t.Fatalf("parseSizeRules(%q) must be error", r)
+		}
+	}
+}
+
+func TestWatcherMaxWatches(t *testing.T) {
+	*maxWatches = 2
+	t.Cleanup(func() { *maxWatches = 0 })
+
+	tmpdir := t.TempDir()
+	for _, d := range []string{"a", "b", "c"} {
+		if err := os.MkdirAll(path.Join(tmpdir, d), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+	}
+
+	// tmpdir and "a" are watched.
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**/file"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		file   string
+		detect bool
+	}{
+		{path.Join(tmpdir, "file"), true},
+		{path.Join(tmpdir, "a", "file"), true},
+		{path.Join(tmpdir, "b", "file"), false},
+		{path.Join(tmpdir, "c", "file"), false},
+	}
+	for _, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		touchFile(test.file)
+		select {
+		case f := <-modC:
+			if f.name != test.file {
+				t.Fatalf("unexpected file modified: %q, wants %q", f.name, test.file)
+			}
+			if !test.detect {
+				t.Fatalf("must not be detect: %q", f.name)
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect: %q", test.file)
+			}
+		}
+	}
+
+	// new directory is not watched, but arelo keeps running.
+	os.Mkdir(path.Join(tmpdir, "d"), 0755)
+	<-time.After(time.Second / 5)
+	clearChan(modC, errC)
+	touchFile(path.Join(tmpdir, "d", "file"))
+	select {
+	case f := <-modC:
+		t.Fatalf("must not be detect: %q", f.name)
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(time.Second / 5):
+	}
+}
+
+func TestWatcherSymlinkCreate(t *testing.T) {
+	for _, opt := range []bool{false, true} {
+		*symlinkCreate = opt
+		t.Cleanup(func() { *symlinkCreate = false })
+
+		tmpdir := t.TempDir()
+		touchFile(path.Join(tmpdir, "exists"))
+		modC, errC, err := watcher([]string{tmpdir}, []string{"**/link*"}, nil, 0)
+		if err != nil {
+			t.Fatalf("watcher: %v", err)
+		}
+
+		tests := []struct {
+			target string
+			detect bool
+		}{
+			{path.Join(tmpdir, "exists"), true},
+			{path.Join(tmpdir, "notexists"), opt},
+		}
+		for i, test := range tests {
+			<-time.After(time.Second / 5)
+			clearChan(modC, errC)
+			link := path.Join(tmpdir, fmt.Sprintf("link%d", i))
+			if err := os.Symlink(test.target, link); err != nil {
+				t.Fatalf("Symlink: %v", err)
+			}
+			select {
+			case f := <-modC:
+				if f.name != link {
+					t.Fatalf("unexpected file modified: %q, wants %q", f.name, link)
+				}
+				if !test.detect {
+					t.Fatalf("must not be detect: %q -> %q (%v)", f.name, test.target, opt)
+				}
+			case e := <-errC:
+				t.Fatalf("watcher error: %v", e)
+			case <-time.After(time.Second / 5):
+				if test.detect {
+					t.Fatalf("must be detect: %q -> %q (%v)", link, test.target, opt)
+				}
+			}
+		}
+	}
+}
+
+func TestWatcherTargetRenamed(t *testing.T) {
+	tmpdir := t.TempDir()
+	parent := path.Join(tmpdir, "parent")
+	target := path.Join(parent, "target")
+	if err := os.MkdirAll(path.Join(target, "sub"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	*watchTargetPar = true
+	t.Cleanup(func() { *watchTargetPar = false })
+	modC, errC, err := watcher([]string{target}, []string{"**/file"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		op     func() error
+		file   string
+		detect bool
+	}{
+		// the renamed target is no longer watched.
+		{func() error { return os.Rename(target, target+"2") }, "", false},
+		{func() error { touchFile(path.Join(target+"2", "sub", "file")); return nil }, "", false},
+		// the target created again is watched.
+		{func() error { return os.Mkdir(target, 0755) }, "", false},
+		{func() error { touchFile(path.Join(target, "file")); return nil }, path.Join(target, "file"), true},
+		// the target in the renamed parent is no longer watched.
+		{func() error { return os.Rename(parent, parent+"2") }, "", false},
+		{func() error { touchFile(path.Join(parent+"2", "target", "file")); return nil }, "", false},
+	}
+	for i, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		if err := test.op(); err != nil {
+			t.Fatalf("%v: %v", i, err)
 		}
+		select {
+		case f := <-modC:
+			if !test.detect {
+				t.Fatalf("%v: must not be detect: %q", i, f.name)
+			}
+			if f.name != test.file {
+				t.Fatalf("%v: unexpected file modified: %q, wants %q", i, f.name, test.file)
+			}
+		case e := <-errC:
+			t.Fatalf("%v: watcher error: %v", i, e)
+		case <-time.After(time.Second / 2):
+			if test.detect {
+				t.Fatalf("%v: must be detect: %q", i, test.file)
+			}
+		}
+	}
+}
+
+func TestWatcherTargetRenamedWithoutParents(t *testing.T) {
+	tmpdir := t.TempDir()
+	target := path.Join(tmpdir, "target")
+	if err := os.MkdirAll(path.Join(target, "sub"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	modC, errC, err := watcher([]string{target}, []string{"**/file"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		op     func() error
+		file   string
+		detect bool
+	}{
+		{func() error { touchFile(path.Join(target, "sub", "file")); return nil }, path.Join(target, "sub", "file"), true},
+		// the renamed target is no longer watched, and not watched again without the parent.
+		{func() error { return os.Rename(target, target+"2") }, "", false},
+		{func() error { touchFile(path.Join(target+"2", "sub", "file")); return nil }, "", false},
+		{func() error { return os.Mkdir(target, 0755) }, "", false},
+		{func() error { touchFile(path.Join(target, "file")); return nil }, "", false},
+	}
+	for i, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		if err := test.op(); err != nil {
+			t.Fatalf("%v: %v", i, err)
+		}
+		select {
+		case f := <-modC:
+			if !test.detect {
+				t.Fatalf("%v: must not be detect: %q", i, f.name)
+			}
+			if f.name != test.file {
+				t.Fatalf("%v: unexpected file modified: %q, wants %q", i, f.name, test.file)
+			}
+		case e := <-errC:
+			t.Fatalf("%v: watcher error: %v", i, e)
+		case <-time.After(time.Second / 2):
+			if test.detect {
+				t.Fatalf("%v: must be detect: %q", i, test.file)
+			}
+		}
+	}
+}
+
+func TestWatchParents(t *testing.T) {
+	tmpdir := t.TempDir()
+	sub := path.Join(tmpdir, "sub")
+	file := path.Join(tmpdir, "file")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	touchFile(file)
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	defer w.Close()
+
+	// the parent of the current directory is not watched.
+	guards, files := watchParents(w, tmpdir, []string{".", tmpdir}, map[string]bool{})
+	if len(guards) != 0 || len(files) != 0 {
+		t.Fatalf("guards of the current directory = %v, %v, wants none", guards, files)
+	}
+
+	if err := w.Add(file); err != nil { // as addTargets
+		t.Fatalf("Add: %v", err)
+	}
+	guards, files = watchParents(w, tmpdir, []string{sub, file}, map[string]bool{sub: true})
+	if len(guards) != 1 || len(guards[filepath.Clean(tmpdir)]) != 2 {
+		t.Fatalf("guards = %v, wants the parent of %q and %q", guards, sub, file)
+	}
+	if !files[filepath.Clean(file)] {
+		t.Fatalf("files = %v, wants %q", files, file)
+	}
+	// the file target is still watched by itself.
+	if !slices.Contains(w.WatchList(), file) {
+		t.Fatalf("watch list = %q, wants %q", w.WatchList(), file)
+	}
+}
+
+func TestWatcherTargetFileNotDuplicated(t *testing.T) {
+	tmpdir := t.TempDir()
+	target := path.Join(tmpdir, "target")
+	touchFile(target)
+	*watchTargetPar = true
+	t.Cleanup(func() { *watchTargetPar = false })
+	modC, errC, err := watcher([]string{target}, []string{"**"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+	<-time.After(time.Second / 5)
+	clearChan(modC, errC)
+
+	if err := os.WriteFile(target, []byte("data"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	n := 0
+	timeout := time.After(time.Second / 2)
+	for loop := true; loop; {
+		select {
+		case f := <-modC:
+			if f.name != target {
+				t.Fatalf("unexpected file modified: %q, wants %q", f.name, target)
+			}
+			n++
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-timeout:
+			loop = false
+		}
+	}
+	if n != 1 {
+		t.Fatalf("%q is detected %d times, wants once", target, n)
+	}
+}
+
+func TestWatcherTargetTypeChanged(t *testing.T) {
+	tmpdir := t.TempDir()
+	target := path.Join(tmpdir, "target")
+	inner := path.Join(target, "file")
+	touchFile(target)
+	*watchTargetPar = true
+	t.Cleanup(func() { *watchTargetPar = false })
+	modC, errC, err := watcher([]string{target}, []string{"**"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		op     func() error
+		file   string
+		detect bool
+	}{
+		{func() error { touchFile(target); return nil }, target, true},
+		// the file is replaced by a directory.
+		{func() error { return os.Remove(target) }, target, true},
+		{func() error { return os.Mkdir(target, 0755) }, "", false},
+		{func() error { touchFile(inner); return nil }, inner, true},
+		// the directory is replaced by a file.
+		{func() error { return os.RemoveAll(target) }, inner, true},
+		{func() error { touchFile(target); return nil }, target, true},
+		{func() error { touchFile(target); return nil }, target, true},
+		// and by a directory again.
+		{func() error { return os.Remove(target) }, target, true},
+		{func() error { return os.Mkdir(target, 0755) }, "", false},
+		{func() error { touchFile(inner); return nil }, inner, true},
+	}
+	for i, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		if err := test.op(); err != nil {
+			t.Fatalf("%v: %v", i, err)
+		}
+		select {
+		case f := <-modC:
+			if !test.detect {
+				t.Fatalf("%v: must not be detect: %q", i, f.name)
+			}
+			if f.name != test.file {
+				t.Fatalf("%v: unexpected file modified: %q, wants %q", i, f.name, test.file)
+			}
+		case e := <-errC:
+			t.Fatalf("%v: watcher error: %v", i, e)
+		case <-time.After(time.Second / 2):
+			if test.detect {
+				t.Fatalf("%v: must be detect: %q", i, test.file)
+			}
+		}
+	}
+}
+
+func TestEventRate(t *testing.T) {
+	r := newEventRate(3)
+	now := time.Now()
+	names := []string{"a", "b", "b", "c"}
+	for i, name := range names {
+		top, c, warn := r.add(name, now)
+		if i < 3 {
+			if warn {
+				t.Fatalf("%v: must not warn", i)
+			}
+			continue
+		}
+		if !warn || top != "b" || c != 2 {
+			t.Fatalf("%v: add() = %q, %v, %v, wants \"b\", 2, true", i, top, c, warn)
+		}
+	}
+
+	// warned once in the interval
+	for i := 0; i < 10; i++ {
+		if _, _, warn := r.add("d", now.Add(time.Second*2)); warn {
+			t.Fatalf("must not warn again in the interval")
+		}
+	}
+	if top, _, warn := r.add("d", now.Add(eventRateWarnInterval+time.Second)); warn {
+		t.Fatalf("must not warn in the new second: %q", top)
+	}
+
+	// disabled
+	r = newEventRate(0)
+	for i := 0; i < 10; i++ {
+		if _, _, warn := r.add("a", now); warn {
+			t.Fatalf("must not warn when disabled")
+		}
+	}
+}
+
+func TestFileCooldown(t *testing.T) {
+	c := newFileCooldown(time.Second)
+	now := time.Now()
+	tests := []struct {
+		name  string
+		after time.Duration
+		wants bool
+	}{
+		{"a", 0, true},
+		{"b", 0, true}, // other files are not affected
+		{"a", time.Second / 2, false},
+		{"a", time.Second, true},
+		{"a", time.Second * 3 / 2, false},
+	}
+	for i, test := range tests {
+		if ok := c.allow(test.name, now.Add(test.after)); ok != test.wants {
+			t.Fatalf("%v: allow(%q, +%v) = %v, wants %v", i, test.name, test.after, ok, test.wants)
+		}
+	}
+
+	// disabled
+	c = newFileCooldown(0)
+	for i := 0; i < 3; i++ {
+		if !c.allow("a", now) {
+			t.Fatalf("must allow when disabled")
+		}
+	}
+}
+
+func TestWatcherIgnoredNewDir(t *testing.T) {
+	tmpdir := t.TempDir()
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**/file"}, []string{"**/node_modules/**"}, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	// the ignored directory created at runtime is not watched.
+	dir := path.Join(tmpdir, "sub", "node_modules", "pkg")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	tests := []struct {
+		file   string
+		detect bool
+	}{
+		{path.Join(dir, "file"), false},
+		{path.Join(tmpdir, "sub", "node_modules", "file"), false},
+		{path.Join(tmpdir, "sub", "file"), true},
+	}
+	for _, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		touchFile(test.file)
+		select {
+		case f := <-modC:
+			if f.name != test.file {
+				t.Fatalf("unexpected file modified: %q, wants %q", f.name, test.file)
+			}
+			if !test.detect {
+				t.Fatalf("must not be detect: %q", f.name)
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect: %q", test.file)
+			}
+		}
+	}
+}
+
+func TestWatcherDirChange(t *testing.T) {
+	*dirchg = true
+	t.Cleanup(func() { *dirchg = false })
+
+	tmpdir := t.TempDir()
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**/file"}, []string{"**/ignore"}, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		dir    string
+		remove bool
+		detect bool
+	}{
+		{path.Join(tmpdir, "sub"), false, true},
+		{path.Join(tmpdir, "sub"), true, true},
+		{path.Join(tmpdir, "ignore"), false, false},
+		{path.Join(tmpdir, "ignore"), true, false},
+	}
+	for _, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		if test.remove {
+			os.Remove(test.dir)
+		} else {
+			os.Mkdir(test.dir, 0755)
+		}
+		select {
+		case f := <-modC:
+			if f.name != test.dir {
+				t.Fatalf("unexpected directory changed: %q, wants %q", f.name, test.dir)
+			}
+			if !test.detect {
+				t.Fatalf("must not be detect: %q", f.name)
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect: %q (remove=%v)", test.dir, test.remove)
+			}
+		}
+	}
+}
+
+func TestWatcherRemoveGrace(t *testing.T) {
+	*removeGrace = time.Second / 2
+	t.Cleanup(func() { *removeGrace = 0 })
+
+	tmpdir := t.TempDir()
+	file := path.Join(tmpdir, "file")
+	touchFile(file)
+
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**/file"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	// remove and create again: only one trigger.
+	os.Remove(file)
+	<-time.After(time.Second / 10)
+	touchFile(file)
+
+	select {
+	case f := <-modC:
+		if f.name != file {
+			t.Fatalf("unexpected file modified: %q, wants %q", f.name, file)
+		}
+		if f.op != fsnotify.Write {
+			t.Fatalf("op of the merged trigger = %v, wants WRITE", opString(f.op))
+		}
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(time.Second / 5):
+		t.Fatalf("must be detect: %q", file)
+	}
+	// the held removal must not be triggered after the grace window.
+	<-time.After(time.Second / 10)
+	clearChan(modC, errC)
+	select {
+	case f := <-modC:
+		t.Fatalf("must not be detect: %q", f.name)
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(*removeGrace):
+	}
+
+	// remove only: triggered after the grace window.
+	start := time.Now()
+	os.Remove(file)
+	select {
+	case f := <-modC:
+		if f.name != file {
+			t.Fatalf("unexpected file modified: %q, wants %q", f.name, file)
+		}
+		if d := time.Since(start); d < *removeGrace {
+			t.Fatalf("triggered before the grace window: %v", d)
+		}
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(time.Second):
+		t.Fatalf("must be detect: %q", file)
+	}
+}
+
+func TestWatcherRemoveGraceFiltered(t *testing.T) {
+	*removeGrace = time.Second / 2
+	*cooldownOpt = 2 * time.Second
+	t.Cleanup(func() {
+		*removeGrace = 0
+		*cooldownOpt = 0
+	})
+
+	tmpdir := t.TempDir()
+	file := path.Join(tmpdir, "file")
+	touchFile(file)
+
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**/file"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	// the creation dropped by the cooldown still cancels the held removal.
+	os.Remove(file)
+	<-time.After(time.Second / 10)
+	touchFile(file)
+	select {
+	case f := <-modC:
+		t.Fatalf("must not be detect: %v %q", opString(f.op), f.name)
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(*removeGrace + time.Second/5):
+	}
+}
+
+func TestWatcherSymlinkTarget(t *testing.T) {
+	*resolveLinks = true
+	t.Cleanup(func() { *resolveLinks = false })
+
+	tmpdir := t.TempDir()
+	file := path.Join(tmpdir, "real", "file")
+	link := path.Join(tmpdir, "link")
+	if err := os.Mkdir(path.Join(tmpdir, "real"), 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	touchFile(file)
+	if err := os.Symlink(file, link); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+
+	modC, errC, err := watcher([]string{link}, []string{"**/link"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	touchFile(file)
+	select {
+	case f := <-modC:
+		if f.name != link {
+			t.Fatalf("unexpected file modified: %q, wants %q", f.name, link)
+		}
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(time.Second / 5):
+		t.Fatalf("must be detect: %q", link)
+	}
+}
+
+func clearChan(c <-chan trigger, ce <-chan error) {
+	for {
+		select {
+		case <-c:
+		case <-ce:
+		default:
+			return
+		}
+	}
+}
+
+func touchFile(file string) {
+	os.WriteFile(file, []byte("a"), 0644)
+}
+
+func TestMatchPatterns(t *testing.T) {
+	tests := []struct {
+		t, pat string
+		wants  bool
+	}{
+		{"ab/cd/efg", "**/efg", true},
+		{"ab/cd/efg", "*/efg", false},
+		{"./abc.efg", "**/*.efg", true},
+		{"./abc.efg", "*.efg", true},
+		{"./.abc", "**/.*", true},
+		{"./.abc", ".*", true},
+	}
+
+	for _, test := range tests {
+		r, err := matchPatterns(test.t, []string{test.pat})
+		if err != nil {
+			t.Fatalf("matchPatterns(%v, {%v}): %v", test.t, test.pat, err)
+		}
+		if r != test.wants {
+			t.Fatalf("matchPatterns(%v, {%v}) = %v wants %v", test.t, test.pat, r, test.wants)
+		}
+	}
+}
+
+func TestCoveringIgnore(t *testing.T) {
+	tests := []struct {
+		t       string
+		isDir   bool
+		ignores []string
+		wants   string
+	}{
+		{"./build", true, []string{"**/build/**"}, "**/build/**"},
+		{"build", true, []string{"build/*"}, "build/*"},
+		{"build", true, []string{"**/build"}, ""},
+		{"build", true, []string{"build/*.go"}, ""},
+		{"main.go", false, []string{"**/*.go"}, "**/*.go"},
+		{"main.go", false, []string{"**/*_test.go"}, ""},
+		{"src", true, nil, ""},
+	}
+
+	for _, test := range tests {
+		r, err := coveringIgnore(test.t, test.isDir, test.ignores)
+		if err != nil {
+			t.Fatalf("coveringIgnore(%q, %v, %q): %v", test.t, test.isDir, test.ignores, err)
+		}
+		if r != test.wants {
+			t.Fatalf("coveringIgnore(%q, %v, %q) = %q wants %q", test.t, test.isDir, test.ignores, r, test.wants)
+		}
+	}
+}
+
+func TestGitWatcher(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	tmpdir := t.TempDir()
+	git := func(args ...string) {
+		c := exec.Command("git", append([]string{"-C", tmpdir}, args...)...)
+		if out, err := c.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	if err := os.WriteFile(path.Join(tmpdir, ".gitignore"), []byte("build/\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Mkdir(path.Join(tmpdir, "build"), 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	modC, errC, err := gitWatcher(ctx, tmpdir, []string{"."}, []string{"**/*.go"}, nil, time.Second/20)
+	if err != nil {
+		t.Fatalf("gitWatcher: %v", err)
+	}
+
+	tests := []struct {
+		file   string
+		detect bool
+	}{
+		{"main.go", true},
+		{"build/out.go", false}, // ignored by git
+		{"README", false},       // not matched
+		{"sub.go", true},
+	}
+	for _, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		touchFile(path.Join(tmpdir, test.file))
+		select {
+		case f := <-modC:
+			if f.name != test.file {
+				t.Fatalf("unexpected file modified: %q, wants %q", f.name, test.file)
+			}
+			if !test.detect {
+				t.Fatalf("must not be detect: %q", f.name)
+			}
+			if f.op != fsnotify.Create { // untracked
+				t.Fatalf("op of %q = %v, wants CREATE", f.name, opString(f.op))
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect: %q", test.file)
+			}
+		}
+	}
+}
+
+func TestGitWatcherSubdir(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	tmpdir := t.TempDir()
+	sub := path.Join(tmpdir, "sub")
+	if out, err := exec.Command("git", "-C", tmpdir, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	if err := os.MkdirAll(path.Join(sub, "ignore"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	// the patterns and the ignores are relative to the dir, not to the root of the repository.
+	ctx, cancel := context.WithCancel(context.Background())
+	modC, errC, err := gitWatcher(ctx, sub, []string{"."}, []string{"*.go", "ignore/*.go"}, []string{"ignore/**"}, time.Second/20)
+	if err != nil {
+		t.Fatalf("gitWatcher: %v", err)
+	}
+
+	tests := []struct {
+		file   string
+		detect bool
+	}{
+		{"a.go", true},
+		{"ignore/b.go", false},
+		{"../c.go", false}, // not in the targets
+	}
+	for _, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		touchFile(path.Join(sub, test.file))
+		select {
+		case f := <-modC:
+			if !test.detect {
+				t.Fatalf("must not be detect: %q", f.name)
+			}
+			if f.name != test.file {
+				t.Fatalf("unexpected file modified: %q, wants %q", f.name, test.file)
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect: %q", test.file)
+			}
+		}
+	}
+
+	// the polling stops when ctx is done.
+	cancel()
+	select {
+	case _, ok := <-modC:
+		if ok {
+			t.Fatalf("modC must be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("gitWatcher must stop")
+	}
+}
+
+func TestGitOp(t *testing.T) {
+	tests := []struct {
+		old, cur string
+		wants    fsnotify.Op
+	}{
+		{"", "??", fsnotify.Create},
+		{"", " M", fsnotify.Write},
+		{" M", "MM", fsnotify.Write},
+		{" M", "", fsnotify.Write}, // reverted or committed
+		{"", " D", fsnotify.Remove},
+		{"??", "", fsnotify.Write},
+	}
+	for _, test := range tests {
+		if op := gitOp(test.old, test.cur); op != test.wants {
+			t.Fatalf("gitOp(%q, %q) = %v, wants %v", test.old, test.cur, opString(op), opString(test.wants))
+		}
+	}
+
+	// the triggers of the git-status mode are held by --min-changed-files.
+	in := make(chan trigger)
+	out := batchTriggers(in, 2, 0)
+	go func() { in <- trigger{name: "a.go", op: gitOp("", " M")} }()
+	select {
+	case tr := <-out:
+		t.Fatalf("must be held: %v", tr)
+	case <-time.After(time.Second / 10):
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		s     string
+		wants []string
+	}{
+		{"go run ./...", []string{"go", "run", "./..."}},
+		{"  sh  -c 'echo $HOME; ls'\t", []string{"sh", "-c", "echo $HOME; ls"}},
+		{`echo "a 'b' c" d\ e`, []string{"echo", "a 'b' c", "d e"}},
+		{`echo '' "" x`, []string{"echo", "", "", "x"}},
+		{`echo 'a\b'`, []string{"echo", `a\b`}},
+		{"", nil},
+	}
+	for _, test := range tests {
+		args, err := splitCommand(test.s)
+		if err != nil {
+			t.Fatalf("splitCommand(%q): %v", test.s, err)
+		}
+		if !reflect.DeepEqual(args, test.wants) {
+			t.Fatalf("splitCommand(%q) = %q, wants %q", test.s, args, test.wants)
+		}
+	}
+
+	for _, s := range []string{`echo 'abc`, `echo "abc`, `echo abc\`} {
+		if _, err := splitCommand(s); err == nil {
+			t.Fatalf("splitCommand(%q) must be error", s)
+		}
+	}
+}
+
+func TestInterpreterCommand(t *testing.T) {
+	tests := []struct {
+		interp, s string
+		wants     []string
+	}{
+		{"bash -c", "echo $HOME; ls", []string{"bash", "-c", "echo $HOME; ls"}},
+		{"python3 -c", "print('a b')", []string{"python3", "-c", "print('a b')"}},
+		{`"/opt/my shell/sh" -e -c`, "make", []string{"/opt/my shell/sh", "-e", "-c", "make"}},
+	}
+	for _, test := range tests {
+		args, err := interpreterCommand(test.interp, test.s)
+		if err != nil {
+			t.Fatalf("interpreterCommand(%q, %q): %v", test.interp, test.s, err)
+		}
+		if !reflect.DeepEqual(args, test.wants) {
+			t.Fatalf("interpreterCommand(%q, %q) = %q, wants %q", test.interp, test.s, args, test.wants)
+		}
+	}
+
+	for _, interp := range []string{"", "  ", `bash -c '`} {
+		if _, err := interpreterCommand(interp, "ls"); err == nil {
+			t.Fatalf("interpreterCommand(%q) must be error", interp)
+		}
+	}
+}
+
+func TestApplySmart(t *testing.T) {
+	ign, filt, grace, pol, d := *ignores, *filters, *removeGrace, *burst, *delay
+	t.Cleanup(func() {
+		*ignores, *filters, *removeGrace, *burst, *delay = ign, filt, grace, pol, d
+	})
+
+	*ignores = []string{"**/.git"}
+	*delay = delayRange{2 * time.Second, 2 * time.Second}
+	applySmart(func(name string) bool { return name == "delay" })
+
+	if wants := append([]string{"**/.git"}, smartIgnores...); !reflect.DeepEqual(*ignores, wants) {
+		t.Fatalf("ignores = %q, wants %q", *ignores, wants)
+	}
+	if !reflect.DeepEqual(*filters, []string{"CHMOD"}) {
+		t.Fatalf("filters = %q, wants %q", *filters, []string{"CHMOD"})
+	}
+	if *removeGrace != 100*time.Millisecond {
+		t.Fatalf("remove grace = %v, wants %v", *removeGrace, 100*time.Millisecond)
+	}
+	if *burst != "debounce" {
+		t.Fatalf("burst policy = %q, wants %q", *burst, "debounce")
+	}
+	if *delay != (delayRange{2 * time.Second, 2 * time.Second}) {
+		t.Fatalf("delay = %v, wants explicit %v", delay, 2*time.Second)
+	}
+}
+
+func TestDelayRange(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+		str      string
+	}{
+		{"500ms", 500 * time.Millisecond, 500 * time.Millisecond, "500ms"},
+		{"500ms-2s", 500 * time.Millisecond, 2 * time.Second, "500ms-2s"},
+		{"1s-1s", time.Second, time.Second, "1s"},
+	}
+	for _, test := range tests {
+		var d delayRange
+		if err := d.Set(test.value); err != nil {
+			t.Fatalf("Set(%q): %v", test.value, err)
+		}
+		if d.min != test.min || d.max != test.max || d.String() != test.str {
+			t.Fatalf("Set(%q) = %v, %v (%q), wants %v, %v (%q)", test.value, d.min, d.max, d.String(), test.min, test.max, test.str)
+		}
+	}
+	for _, v := range []string{"", "1x", "2s-1s", "1s-", "-1s"} {
+		var d delayRange
+		if err := d.Set(v); err == nil {
+			t.Fatalf("Set(%q) must be error", v)
+		}
+	}
+	var d delayRange
+	if err := d.Set("-1s"); err == nil || !strings.Contains(err.Error(), "negative") {
+		t.Fatalf("Set(%q) = %v, wants the error of the negative delay", "-1s", err)
+	}
+
+	r := newJitter(1)
+	for i := 0; i < 100; i++ {
+		if d := randomDelay(r, time.Second, time.Second); d < time.Second || d > 2*time.Second {
+			t.Fatalf("randomDelay(1s, 1s) = %v", d)
+		}
+	}
+	if d := randomDelay(r, time.Second, 0); d != time.Second {
+		t.Fatalf("randomDelay(1s, 0) = %v", d)
+	}
+
+	// the same seed gives the same delays.
+	seed := jitterSeed
+	t.Cleanup(func() { jitterSeed = seed })
+	jitterSeed = 42
+	r1, r2 := newJitter(1), newJitter(1)
+	for i := 0; i < 10; i++ {
+		if d1, d2 := randomDelay(r1, time.Second, time.Second), randomDelay(r2, time.Second, time.Second); d1 != d2 {
+			t.Fatalf("randomDelay with the same seed: %v != %v", d1, d2)
+		}
+	}
+	if d1, d2 := randomDelay(newJitter(1), 0, time.Hour), randomDelay(newJitter(2), 0, time.Hour); d1 == d2 {
+		t.Fatalf("randomDelay of the different streams: %v == %v", d1, d2)
+	}
+}
+
+func TestVerboseLevel(t *testing.T) {
+	tests := []struct {
+		args []string
+		exp  verboseLevel
+	}{
+		{[]string{}, 0},
+		{[]string{"-v"}, 1},
+		{[]string{"-vv"}, 2},
+		{[]string{"-v", "--verbose"}, 2},
+		{[]string{"--verbose=true"}, 1},
+		{[]string{"--verbose=false"}, 0},
+		{[]string{"-vv", "--verbose=false"}, 0},
+		{[]string{"--verbose=3"}, 3},
+	}
+	for _, test := range tests {
+		var v verboseLevel
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.VarP(&v, "verbose", "v", "")
+		fs.Lookup("verbose").NoOptDefVal = "+1"
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatalf("Parse(%q): %v", test.args, err)
+		}
+		if v != test.exp {
+			t.Fatalf("Parse(%q) = %v, wants %v", test.args, v, test.exp)
+		}
+	}
+	for _, s := range []string{"", "yes", "-1"} {
+		var v verboseLevel
+		if err := v.Set(s); err == nil {
+			t.Fatalf("Set(%q) must be error", s)
+		}
+	}
+}
+
+func TestBatchTriggers(t *testing.T) {
+	in := make(chan trigger)
+	out := batchTriggers(in, 3, time.Second/2)
+	recv := func(timeout time.Duration) string {
+		select {
+		case t := <-out:
+			return t.name
+		case <-time.After(timeout):
+			return ""
+		}
+	}
+
+	tests := []struct {
+		t     trigger
+		wants string
+	}{
+		{trigger{name: "a", op: fsnotify.Write}, ""},
+		{trigger{name: "b", op: fsnotify.Create}, ""},
+		{trigger{name: "a", op: fsnotify.Write}, ""}, // not distinct
+		{trigger{name: "interval"}, "interval"},      // passed through
+		{trigger{name: "c", op: fsnotify.Write}, "c"},
+		{trigger{name: "a", op: fsnotify.Write}, ""}, // next batch
+	}
+	for i, test := range tests {
+		in <- test.t
+		if name := recv(time.Second / 10); name != test.wants {
+			t.Fatalf("%v: %q: out = %q, wants %q", i, test.t.name, name, test.wants)
+		}
+	}
+	// window elapsed
+	if name := recv(time.Second); name != "a" {
+		t.Fatalf("out = %q after the window, wants %q", name, "a")
+	}
+
+	if c := batchTriggers(in, 1, time.Second); c != (<-chan trigger)(in) {
+		t.Fatalf("batchTriggers must return the input when n <= 1")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		list  string
+		sep   string
+		wants []string
+	}{
+		{"a,b,c", ",", []string{"a", "b", "c"}},
+		{"**/*.go, **/*.mod,", ",", []string{"**/*.go", "**/*.mod"}},
+		{"**/*.{go,mod},**/*.{js,{ts,tsx}}", ",", []string{"**/*.{go,mod}", "**/*.{js,{ts,tsx}}"}},
+		{"a,b;c,d", ";", []string{"a,b", "c,d"}},
+		{"a::b", "::", []string{"a", "b"}},
+		{"", ",", nil},
+	}
+	for _, test := range tests {
+		if items := splitList(test.list, test.sep); !reflect.DeepEqual(items, test.wants) {
+			t.Fatalf("splitList(%q, %q) = %q, wants %q", test.list, test.sep, items, test.wants)
+		}
+	}
+}
+
+func TestExpandListArgs(t *testing.T) {
+	args := []optArg{{"targets", "src,lib"}, {"pattern", "**/*.go"}, {"patterns", "**/*.{c,h},**/*.mk"}}
+	wants := []optArg{{"target", "src"}, {"target", "lib"}, {"pattern", "**/*.go"}, {"pattern", "**/*.{c,h}"}, {"pattern", "**/*.mk"}}
+	if expanded := expandListArgs(args, ","); !reflect.DeepEqual(expanded, wants) {
+		t.Fatalf("expandListArgs() = %v, wants %v", expanded, wants)
+	}
+}
+
+func TestApplyEnvOptions(t *testing.T) {
+	env := map[string]string{
+		"ARELO_TARGETS":  "src, lib",
+		"ARELO_PATTERNS": "**/*.{go,mod}",
+		"ARELO_IGNORES":  "**/.git/**,**/vendor/**",
+		"ARELO_DELAY":    "2s",
+		"ARELO_RESTART":  "",
+	}
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	targets := fs.StringArrayP("target", "t", nil, "")
+	fs.StringArray("targets", nil, "")
+	patterns := fs.StringArrayP("pattern", "p", nil, "")
+	fs.StringArray("patterns", nil, "")
+	ignores := fs.StringArrayP("ignore", "i", nil, "")
+	delay := fs.DurationP("delay", "d", time.Second, "")
+	fs.StringP("signal", "s", "", "")
+	restart := fs.BoolP("restart-on-exit", "r", false, "")
+	if err := fs.Parse([]string{"-p", "**/*.js", "-i", "**/node_modules/**"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	args, err := applyEnvOptions(fs, func(k string) string { return env[k] }, ",")
+	if err != nil {
+		t.Fatalf("applyEnvOptions: %v", err)
+	}
+	if !reflect.DeepEqual(*targets, []string{"src", "lib"}) {
+		t.Fatalf("targets = %q, wants %q", *targets, []string{"src", "lib"})
+	}
+	if !reflect.DeepEqual(*patterns, []string{"**/*.js"}) { // overridden by the command line
+		t.Fatalf("patterns = %q, wants %q", *patterns, []string{"**/*.js"})
+	}
+	if !reflect.DeepEqual(*ignores, []string{"**/node_modules/**"}) {
+		t.Fatalf("ignores = %q, wants %q", *ignores, []string{"**/node_modules/**"})
+	}
+	if *delay != 2*time.Second || *restart {
+		t.Fatalf("delay, restart = %v, %v, wants 2s, false", *delay, *restart)
+	}
+	wants := []optArg{{"target", "src"}, {"target", "lib"}, {"delay", "2s"}}
+	if !reflect.DeepEqual(args, wants) {
+		t.Fatalf("args = %v, wants %v", args, wants)
+	}
+
+	env = map[string]string{"ARELO_RESTART": "maybe"}
+	if _, err := applyEnvOptions(fs, func(k string) string { return env[k] }, ","); err == nil {
+		t.Fatalf("applyEnvOptions must be error for ARELO_RESTART=maybe")
+	}
+}
+
+func TestPrintWatchedTree(t *testing.T) {
+	tmpdir := t.TempDir()
+	for _, d := range []string{"a/b", "a-c", "node_modules/x"} {
+		if err := os.MkdirAll(path.Join(tmpdir, d), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+	}
+	touchFile(path.Join(tmpdir, "file"))
+
+	var buf bytes.Buffer
+	targets := []string{path.Join(tmpdir, "a"), tmpdir, path.Join(tmpdir, "file")}
+	if err := printWatchedTree(&buf, targets, []string{"**"}, []string{"**/node_modules"}, 0); err != nil {
+		t.Fatalf("printWatchedTree: %v", err)
+	}
+	wants := tmpdir + "/\n" +
+		"  a/\n" +
+		"    b/\n" +
+		"  a-c/\n" +
+		"  file\n"
+	if buf.String() != wants {
+		t.Fatalf("printWatchedTree:\n%s\nwants:\n%s", buf.String(), wants)
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	environ := []string{"PATH=/bin", "HOME=/home/arelo", "SECRET=x"}
+	tests := []struct {
+		clean bool
+		envs  []string
+		wants []string
+	}{
+		{false, nil, nil},
+		{false, []string{"A=1"}, []string{"PATH=/bin", "HOME=/home/arelo", "SECRET=x", "A=1"}},
+		{true, nil, []string{"PATH=/bin", "HOME=/home/arelo"}},
+		{true, []string{"A=1", "PATH=/usr/bin"}, []string{"PATH=/bin", "HOME=/home/arelo", "A=1", "PATH=/usr/bin"}},
+	}
+	if runtime.GOOS == "windows" {
+		tests[2].wants = []string{"PATH=/bin"}
+		tests[3].wants = []string{"PATH=/bin", "A=1", "PATH=/usr/bin"}
+	}
+	for _, test := range tests {
+		env, err := commandEnv(environ, test.clean, test.envs)
+		if err != nil {
+			t.Fatalf("commandEnv(%v, %q): %v", test.clean, test.envs, err)
+		}
+		if !reflect.DeepEqual(env, test.wants) {
+			t.Fatalf("commandEnv(%v, %q) = %q, wants %q", test.clean, test.envs, env, test.wants)
+		}
+	}
+
+	for _, e := range []string{"A", "=1"} {
+		if _, err := commandEnv(environ, false, []string{e}); err == nil {
+			t.Fatalf("commandEnv(%q) must be error", e)
+		}
+	}
+}
+
+func TestIgnoredAge(t *testing.T) {
+	tmpdir := t.TempDir()
+	file := path.Join(tmpdir, "file")
+	touchFile(file)
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		older, newer time.Duration
+		ignored      bool
+	}{
+		{file, 0, 0, false},
+		{file, time.Minute, 0, true},
+		{file, 2 * time.Hour, 0, false},
+		{file, 0, 2 * time.Hour, true},
+		{file, 0, time.Minute, false},
+		{path.Join(tmpdir, "removed"), time.Minute, time.Minute, false},
+	}
+	for _, test := range tests {
+		r := ignoredAge(test.name, test.older, test.newer)
+		if (r != "") != test.ignored {
+			t.Fatalf("ignoredAge(%q, %v, %v) = %q, wants ignored=%v", test.name, test.older, test.newer, r, test.ignored)
+		}
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	file := path.Join(t.TempDir(), "ready")
+	ctx := context.Background()
+
+	// created
+	time.AfterFunc(time.Second/5, func() { touchFile(file) })
+	if err := waitReady(ctx, file, modTime(file), time.Second*2, nil); err != nil {
+		t.Fatalf("waitReady: %v", err)
+	}
+
+	// not updated
+	prev := modTime(file)
+	if err := waitReady(ctx, file, prev, time.Second/2, nil); err == nil {
+		t.Fatalf("waitReady must be timeout")
+	}
+
+	// updated
+	time.AfterFunc(time.Second/5, func() {
+		os.Chtimes(file, time.Time{}, prev.Add(time.Second))
+	})
+	if err := waitReady(ctx, file, prev, time.Second*2, nil); err != nil {
+		t.Fatalf("waitReady: %v", err)
+	}
+
+	// exited
+	exited := make(chan struct{})
+	close(exited)
+	if err := waitReady(ctx, file, modTime(file), 0, exited); err == nil {
+		t.Fatalf("waitReady must be error on exit")
+	}
+}
+
+func TestConfirmRestart(t *testing.T) {
+	tests := []struct {
+		input []string
+		wants bool
+	}{
+		{[]string{"y"}, true},
+		{[]string{" YES "}, true},
+		{[]string{"n"}, false},
+		{[]string{""}, false},
+		{nil, false}, // EOF
+	}
+	for _, test := range tests {
+		answers := make(chan string)
+		go func() {
+			<-time.After(time.Second / 10)
+			for _, a := range test.input {
+				answers <- a
+			}
+			close(answers)
+		}()
+		var buf bytes.Buffer
+		r := confirmRestart(context.Background(), answers, &buf, "cmd", trigger{name: "file"})
+		if r != test.wants {
+			t.Fatalf("confirmRestart(%q) = %v, wants %v", test.input, r, test.wants)
+		}
+		if !strings.Contains(buf.String(), "[y/N]") {
+			t.Fatalf("prompt = %q", buf.String())
+		}
+	}
+}
+
+func TestStdinCommands(t *testing.T) {
+	restartC := make(chan trigger, 10)
+	reloadC := make(chan trigger, 10)
+	quit := make(chan struct{})
+
+	in := "restart\n  restart  main.go \nreload\nunknown\n\nquit\nrestart\n"
+	stdinCommands(strings.NewReader(in), restartC, reloadC, quit)
+
+	select {
+	case <-quit:
+	default:
+		t.Fatalf("quit must be closed")
+	}
+	close(restartC)
+	close(reloadC)
+	var restarts, reloads []string
+	for tr := range restartC {
+		restarts = append(restarts, tr.name)
+	}
+	for tr := range reloadC {
+		reloads = append(reloads, tr.name)
+	}
+	if !reflect.DeepEqual(restarts, []string{"stdin", "main.go"}) {
+		t.Fatalf("restarts: %q", restarts)
+	}
+	if !reflect.DeepEqual(reloads, []string{"stdin"}) {
+		t.Fatalf("reloads: %q", reloads)
+	}
+}
+
+func TestFastMatch(t *testing.T) {
+	pats := []string{"**", "**/*.go", "**/*.", "**/*.tar.gz", "**/*.[ch]", "**/*.go/*", "*.go", "**/a.go"}
+	names := []string{"a.go", "./a.go", "dir/a.go", "/abs/dir/a.go", ".go", "a.go/b", "a.tar.gz", "a.gz", "a.c", "a", "a."}
+	for _, p := range pats {
+		for _, n := range names {
+			m, ok := fastMatch(p, n)
+			if !ok {
+				continue
+			}
+			wants, err := matchPatterns(n, []string{p})
+			if err != nil {
+				t.Fatalf("matchPatterns(%q, %q): %v", n, p, err)
+			}
+			if m != wants {
+				t.Fatalf("fastMatch(%q, %q) = %v, wants %v", p, n, m, wants)
+			}
+		}
+	}
+}
+
+func BenchmarkMatchedPattern(b *testing.B) {
+	pats := []string{
+		"**/*.go", "**/*.mod", "**/*.sum", "**/*.html", "**/*.css", "**/*.js", "**/*.ts",
+		"**/*.yaml", "**/*.yml", "**/*.json", "**/*.toml", "**/*.{tmpl,tpl}", "**/Makefile",
+		"src/**/*.proto", "**/testdata/**",
+	}
+	names := []string{"./main.go", "web/static/app.css", "src/api/v1/service.proto", "docs/README.md", "./internal/x/testdata/in.txt"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, n := range names {
+			if _, err := matchedPattern(n, pats); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	if err := validatePatterns("pattern", []string{"**", "**/*.{go,mod}", "src/[a-z]*"}); err != nil {
+		t.Fatalf("validatePatterns: %v", err)
+	}
+	for _, p := range []string{"[abc", "**/*.{go,mod", "a/b\\"} {
+		err := validatePatterns("pattern", []string{"**/*.go", p})
+		if err == nil {
+			t.Fatalf("validatePatterns(%q) must be error", p)
+		}
+		if !strings.Contains(err.Error(), strconv.Quote(p)) {
+			t.Fatalf("error must contain the pattern %q: %v", p, err)
+		}
+		if !xerrors.Is(err, doublestar.ErrBadPattern) {
+			t.Fatalf("error must be ErrBadPattern: %v", err)
+		}
+	}
+}
+
+func TestForwardTriggers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	modC := make(chan trigger)
+	errC := make(chan error)
+	reload := make(chan trigger)
+	res := make(chan error)
+	go func() { res <- forwardTriggers(ctx, modC, errC, reload) }()
+
+	modC <- trigger{name: "file"}
+	if tr := <-reload; tr.name != "file" {
+		t.Fatalf("unexpected trigger: %v", tr)
+	}
+	errC <- xerrors.New("test")
+	if err := <-res; err == nil {
+		t.Fatalf("watcher error must be returned")
+	}
+
+	go func() { res <- forwardTriggers(ctx, modC, errC, reload) }()
+	close(modC)
+	if err := <-res; err == nil {
+		t.Fatalf("watcher closed must be returned")
+	}
+
+	// stops on cancel
+	go func() { res <- forwardTriggers(ctx, make(chan trigger, 1), errC, reload) }()
+	cancel()
+	if err := <-res; err != nil {
+		t.Fatalf("forwardTriggers: %v", err)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reload := make(chan trigger)
+	go scheduler(ctx, time.Second/20, reload)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case tr := <-reload:
+			if tr.name != "scheduled run" {
+				t.Fatalf("unexpected trigger: %v", tr)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("scheduled run is not triggered")
+		}
+	}
+
+	cancel()
+	<-time.After(time.Second / 10)
+	select {
+	case tr := <-reload:
+		t.Fatalf("triggered after cancel: %v", tr)
+	case <-time.After(time.Second / 5):
+	}
+}
+
+func TestAddDirRecursiveVanished(t *testing.T) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	defer w.Close()
+
+	dirs := make(map[string]bool)
+	vanished := path.Join(t.TempDir(), "vanished")
+	if err := addDirRecursive(w, nil, vanished, []string{"**"}, nil, dirs, 0, nil); err != nil {
+		t.Fatalf("addDirRecursive must tolerate the vanished directory: %v", err)
+	}
+	if len(dirs) != 0 {
+		t.Fatalf("vanished directory must not be recorded: %v", dirs)
+	}
+}
+
+func TestWatcherWatchAlso(t *testing.T) {
+	tmpdir := t.TempDir()
+	src := path.Join(tmpdir, "src")
+	conf := path.Join(tmpdir, "config")
+	for _, d := range []string{src, conf} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("Mkdir: %v", err)
+		}
+	}
+	app := path.Join(conf, "app.yaml")
+	touchFile(app)
+
+	*watchAlsoOpt = []string{app}
+	t.Cleanup(func() { *watchAlsoOpt = nil })
+
+	modC, errC, err := watcher([]string{src}, []string{"**/*.go"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		op     func()
+		detect string
+	}{
+		{func() { touchFile(path.Join(src, "main.go")) }, path.Join(src, "main.go")},
+		{func() { touchFile(app) }, app}, // not matched to the patterns, but watched also
+		{func() { touchFile(path.Join(conf, "other.yaml")) }, ""},
+		{func() { // replaced by the editor
+			tmp := path.Join(conf, "app.yaml.tmp")
+			touchFile(tmp)
+			os.Rename(tmp, app)
+		}, app},
+		{func() { touchFile(app) }, app}, // still watched after the replacement
+	}
+	for i, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		test.op()
+		select {
+		case f := <-modC:
+			if f.name != test.detect {
+				t.Fatalf("%v: detect %q, wants %q", i, f.name, test.detect)
+			}
+		case e := <-errC:
+			t.Fatalf("%v: watcher error: %v", i, e)
+		case <-time.After(time.Second / 5):
+			if test.detect != "" {
+				t.Fatalf("%v: must be detect: %q", i, test.detect)
+			}
+		}
+	}
+}
+
+func TestWatcherDebounce(t *testing.T) {
+	*debounceOpt = time.Second / 5
+	t.Cleanup(func() { *debounceOpt = 0 })
+
+	tmpdir := t.TempDir()
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	file := path.Join(tmpdir, "file")
+	other := path.Join(tmpdir, "other")
+	<-time.After(time.Second / 5)
+	os.WriteFile(file, []byte("a"), 0644)
+	os.Chmod(file, 0600)
+	<-time.After(time.Second / 10)
+	os.WriteFile(file, []byte("b"), 0644) // resets the timer of the file
+	os.WriteFile(other, []byte("a"), 0644)
+
+	got := make(map[string]fsnotify.Op)
+	timeout := time.After(time.Second)
+	for len(got) < 2 {
+		select {
+		case f := <-modC:
+			if _, ok := got[f.name]; ok {
+				t.Fatalf("triggered twice: %v", f)
+			}
+			got[f.name] = f.op
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-timeout:
+			t.Fatalf("must be detect: %v", got)
+		}
+	}
+	if op := got[file]; !op.Has(fsnotify.Create) || !op.Has(fsnotify.Write) || !op.Has(fsnotify.Chmod) {
+		t.Fatalf("coalesced op of %q = %v, wants CREATE|WRITE|CHMOD", file, op)
+	}
+	if _, ok := got[other]; !ok {
+		t.Fatalf("must be detect: %q", other)
+	}
+	select {
+	case f := <-modC:
+		t.Fatalf("triggered twice: %v", f)
+	case <-time.After(time.Second / 2):
+	}
+}
+
+func TestOldFlagNames(t *testing.T) {
+	for _, args := range [][]string{{"--restart"}, {"--restart-on-exit"}, {"-r"}} {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.SetNormalizeFunc(oldFlagNames)
+		restart := fs.BoolP("restart-on-exit", "r", false, "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatalf("Parse(%q): %v", args, err)
+		}
+		if !*restart || !fs.Changed("restart-on-exit") {
+			t.Fatalf("%q: restart-on-exit must be set", args)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("ARELO_TEST_DIR", "src")
+	t.Setenv("ARELO_TEST_EMPTY", "")
+	tests := []struct {
+		s, wants string
+	}{
+		{"$ARELO_TEST_DIR/**", "src/**"},
+		{"${ARELO_TEST_DIR}_test", "src_test"},
+		{"a${ARELO_TEST_EMPTY}b$ARELO_TEST_UNSET", "ab"},
+		{"$$ARELO_TEST_DIR costs $$5", "$ARELO_TEST_DIR costs $5"},
+		{"echo $1 $? $@", "echo $1 $? $@"},
+		{"kill $(cat pid)", "kill $(cat pid)"},
+		{"no variables", "no variables"},
+	}
+	for _, test := range tests {
+		if r := expandEnv(test.s); r != test.wants {
+			t.Fatalf("expandEnv(%q) = %q, wants %q", test.s, r, test.wants)
+		}
+	}
+}
+
+func TestWatcherDebounceLowOps(t *testing.T) {
+	*debounceOpt = time.Second / 5
+	t.Cleanup(func() { *debounceOpt = 0 })
+
+	tmpdir := t.TempDir()
+	file := path.Join(tmpdir, "file")
+	touchFile(file)
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	<-time.After(time.Second / 5)
+	start := time.Now()
+	touchFile(file)
+	<-time.After(time.Second * 3 / 20)
+	os.Chmod(file, 0600) // merged, but does not extend the quiet period
+
+	select {
+	case f := <-modC:
+		if d := time.Since(start); d > time.Second*3/10 {
+			t.Fatalf("triggered after %v, the chmod must not extend the quiet period (%v)", d, *debounceOpt)
+		}
+		if !f.op.Has(fsnotify.Write) || !f.op.Has(fsnotify.Chmod) {
+			t.Fatalf("coalesced op of %q = %v, wants WRITE|CHMOD", f.name, f.op)
+		}
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(time.Second):
+		t.Fatalf("must be detect: %q", file)
+	}
+	select {
+	case f := <-modC:
+		t.Fatalf("triggered twice: %v", f)
+	case <-time.After(time.Second / 2):
 	}
 }
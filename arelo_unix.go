@@ -7,10 +7,18 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"os/user"
+	"strconv"
 	"strings"
 	"syscall"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
 )
 
+// dryRunSignal is the signal to arelo to test the kill path (--dry-signal).
+var dryRunSignal os.Signal = syscall.SIGUSR1
+
 func parseSignalOption(str string) (os.Signal, string) {
 	switch strings.ToUpper(str) {
 	case "1", "HUP", "SIGHUP", "SIG_HUP":
@@ -34,6 +42,13 @@ func parseSignalOption(str string) (os.Signal, string) {
 	return nil, fmt.Sprintf("unspported signal: %s", str)
 }
 
+func signalName(sig syscall.Signal) string {
+	if name := unix.SignalName(sig); name != "" {
+		return name
+	}
+	return sig.String()
+}
+
 // makeChildDoneChan returns a chan that notifies the child process has exited.
 //
 // On UNIX like OS, it is notified by SIGCHLD.
@@ -53,12 +68,91 @@ func makeChildDoneChan() <-chan struct{} {
 	return c
 }
 
+// credential to run the command as, set by setupCredential.
+var credential *syscall.Credential
+
+// setupCredential resolves the user and group to run the command as.
+// The user and group can be names or numeric IDs.
+func setupCredential(usr, grp string) error {
+	if usr == "" && grp == "" {
+		return nil
+	}
+	cred := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	if usr != "" {
+		lookup := user.Lookup
+		if _, err := strconv.Atoi(usr); err == nil {
+			lookup = user.LookupId
+		}
+		u, err := lookup(usr)
+		if err != nil {
+			return xerrors.Errorf("lookup user %q: %w", usr, err)
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return xerrors.Errorf("uid %q: %w", u.Uid, err)
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return xerrors.Errorf("gid %q: %w", u.Gid, err)
+		}
+		cred.Uid = uint32(uid)
+		cred.Gid = uint32(gid)
+	}
+	if grp != "" {
+		lookup := user.LookupGroup
+		if _, err := strconv.Atoi(grp); err == nil {
+			lookup = user.LookupGroupId
+		}
+		g, err := lookup(grp)
+		if err != nil {
+			return xerrors.Errorf("lookup group %q: %w", grp, err)
+		}
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return xerrors.Errorf("gid %q: %w", g.Gid, err)
+		}
+		cred.Gid = uint32(gid)
+	}
+	credential = cred
+	return nil
+}
+
+// baseEnv is the environment variables retained by --clean-env.
+var baseEnv = []string{"PATH", "HOME"}
+
+// newSessionAvailable reports whether --new-session is available.
+const newSessionAvailable = true
+
 func prepareCommand(cmd []string) *exec.Cmd {
 	c := exec.Command(cmd[0], cmd[1:]...)
-	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Credential: credential,
+	}
+	c.Env = cmdEnv
+	if *newSession {
+		// the session leader is also the leader of a new process group (pgid == pid),
+		// so killChilds still signals the whole group.
+		// setpgid() fails for the session leader.
+		c.SysProcAttr.Setsid = true
+		c.SysProcAttr.Setpgid = false
+	}
 	return c
 }
 
+// setPriority sets the nice value of the process group of the command.
+func setPriority(c *exec.Cmd, nice int) error {
+	return unix.Setpriority(unix.PRIO_PGRP, c.Process.Pid, nice)
+}
+
+// shellCommand returns the command line to run the str by the shell.
+func shellCommand(str string) []string {
+	return []string{"/bin/sh", "-c", str}
+}
+
 func waitCmd(cmd *exec.Cmd) error {
 	return cmd.Wait()
 }
@@ -3,9 +3,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"os"
+	"os/exec"
+	"path"
+	"sync"
 	"syscall"
 	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 func TestParseSignalOption(t *testing.T) {
@@ -32,3 +40,425 @@ func TestParseSignalOption(t *testing.T) {
 		}
 	}
 }
+
+func TestWatcherSpecialFile(t *testing.T) {
+	tmpdir := t.TempDir()
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	fifo := path.Join(tmpdir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Fatalf("Mkfifo: %v", err)
+	}
+	select {
+	case f := <-modC:
+		t.Fatalf("must not be detect: %q", f.name)
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(time.Second / 5):
+	}
+
+	file := path.Join(tmpdir, "file")
+	os.WriteFile(file, []byte("a"), 0644)
+	select {
+	case f := <-modC:
+		if f.name != file {
+			t.Fatalf("unexpected file modified: %q, wants %q", f.name, file)
+		}
+	case e := <-errC:
+		t.Fatalf("watcher error: %v", e)
+	case <-time.After(time.Second / 5):
+		t.Fatalf("must be detect: %q", file)
+	}
+}
+
+func TestRunnerOnDemand(t *testing.T) {
+	*onDemand = true
+	*watchStdin = true // do not read stdin
+	t.Cleanup(func() {
+		*onDemand = false
+		*watchStdin = false
+	})
+
+	out := path.Join(t.TempDir(), "out")
+	runs := func() int {
+		b, _ := os.ReadFile(out)
+		return len(b)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	reload := runner(ctx, &wg, []string{"sh", "-c", "printf x >> " + out}, 0, syscall.SIGTERM, false)
+
+	steps := []struct {
+		trigger bool
+		runs    int
+	}{
+		{false, 0}, // not started until the first trigger
+		{true, 1},  // started by the trigger
+		{false, 1}, // exited, not restarted
+		{true, 2},  // started again by the trigger
+	}
+	for i, s := range steps {
+		if s.trigger {
+			reload <- trigger{name: "file"}
+		}
+		<-time.After(time.Second / 2)
+		if r := runs(); r != s.runs {
+			t.Fatalf("step %d: runs = %d, wants %d", i, r, s.runs)
+		}
+	}
+}
+
+func TestRunCmdOutputHeld(t *testing.T) {
+	// the background child keeps the pipe of the output open after the command exited.
+	var out bytes.Buffer
+	cmd := []string{"sh", "-c", "sleep 3 & echo started"}
+	start := time.Now()
+	err := runCmd(context.Background(), cmd, syscall.SIGTERM, nil, &out, &out)
+	if err != nil {
+		t.Fatalf("runCmd: %v", err)
+	}
+	if d := time.Since(start); d > outputWaitDelay+time.Second {
+		t.Fatalf("runCmd waited for the child: %v", d)
+	}
+	if out.String() != "started\n" {
+		t.Fatalf("output = %q, wants %q", out.String(), "started\n")
+	}
+}
+
+func TestRunCmdTextBusy(t *testing.T) {
+	exe := path.Join(t.TempDir(), "cmd.sh")
+	f, err := os.OpenFile(exe, os.O_WRONLY|os.O_CREATE, 0755)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.WriteString("#!/bin/sh\nexit 0\n"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+	// the executable is busy while it is opened for writing.
+	time.AfterFunc(time.Second/5, func() { f.Close() })
+
+	if err := runCmd(context.Background(), []string{exe}, syscall.SIGTERM, nil, os.Stdout, os.Stderr); err != nil {
+		t.Fatalf("runCmd: %v", err)
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer null.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+	file, err := os.Create(path.Join(t.TempDir(), "file"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer file.Close()
+
+	for _, f := range []*os.File{null, r, file} {
+		if isTerminal(f) {
+			t.Fatalf("%v must not be a terminal", f.Name())
+		}
+	}
+}
+
+func TestWatcherChmodExec(t *testing.T) {
+	*onChmod = "exec"
+	t.Cleanup(func() { *onChmod = "any" })
+
+	tmpdir := t.TempDir()
+	file := path.Join(tmpdir, "script.sh")
+	os.WriteFile(file, []byte("#!/bin/sh\n"), 0644)
+
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	tests := []struct {
+		mode   os.FileMode
+		detect bool
+	}{
+		{0600, false},
+		{0700, true}, // gains the executable bit
+		{0755, true},
+		{0555, false},
+		{0444, true}, // loses the executable bits
+	}
+	for _, test := range tests {
+		<-time.After(time.Second / 5)
+		clearChan(modC, errC)
+		os.Chmod(file, test.mode)
+		select {
+		case f := <-modC:
+			if !test.detect {
+				t.Fatalf("must not be detect: %q %v", f.name, test.mode)
+			}
+		case e := <-errC:
+			t.Fatalf("watcher error: %v", e)
+		case <-time.After(time.Second / 5):
+			if test.detect {
+				t.Fatalf("must be detect: %q %v", file, test.mode)
+			}
+		}
+	}
+}
+
+func TestRunnerMaxRestarts(t *testing.T) {
+	*maxRestarts = 2
+	*watchStdin = true // do not read stdin
+	t.Cleanup(func() {
+		*maxRestarts = 0
+		*watchStdin = false
+	})
+
+	out := path.Join(t.TempDir(), "out")
+	runs := func() int {
+		b, _ := os.ReadFile(out)
+		return len(b)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	reload := runner(ctx, &wg, []string{"sh", "-c", "printf x >> " + out + "; exit 1"}, 0, syscall.SIGTERM, true)
+
+	<-time.After(time.Second / 2)
+	if r := runs(); r != 3 {
+		t.Fatalf("runs = %d, wants 3 (first run and 2 restarts)", r)
+	}
+	reload <- trigger{name: "file"}
+	<-time.After(time.Second / 2)
+	if r := runs(); r != 6 {
+		t.Fatalf("runs = %d, wants 6 (restarted 2 times again after the reset)", r)
+	}
+}
+
+func TestRunnerDebounceLowOps(t *testing.T) {
+	*burst = "debounce"
+	*watchStdin = true // do not read stdin
+	t.Cleanup(func() {
+		*burst = "drop"
+		*watchStdin = false
+	})
+
+	tests := []struct {
+		op   fsnotify.Op
+		runs int // at 550ms after the first trigger
+	}{
+		{fsnotify.Chmod, 2},                  // not extended: restarted at 400ms
+		{fsnotify.Write, 1},                  // extended: restarted at 700ms
+		{fsnotify.Write | fsnotify.Chmod, 1}, // extended by the write
+	}
+	for _, test := range tests {
+		out := path.Join(t.TempDir(), "out")
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		reload := runner(ctx, &wg, []string{"sh", "-c", "printf x >> " + out + "; exec sleep 10"}, 400*time.Millisecond, syscall.SIGTERM, false)
+
+		<-time.After(time.Second / 5)
+		reload <- trigger{name: "file", op: fsnotify.Write}
+		for i := 0; i < 3; i++ {
+			<-time.After(time.Second / 10)
+			reload <- trigger{name: "file", op: test.op}
+		}
+		<-time.After(time.Second / 4)
+		b, _ := os.ReadFile(out)
+		cancel()
+		wg.Wait()
+		if len(b) != test.runs {
+			t.Fatalf("%v: runs = %d, wants %d", test.op, len(b), test.runs)
+		}
+	}
+}
+
+func TestRunnerDelayFirst(t *testing.T) {
+	*delayFirst = true
+	*watchStdin = true // do not read stdin
+	t.Cleanup(func() {
+		*delayFirst = false
+		*watchStdin = false
+	})
+
+	out := path.Join(t.TempDir(), "out")
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	reload := runner(ctx, &wg, []string{"sh", "-c", "printf x >> " + out + "; exec sleep 10"}, 400*time.Millisecond, syscall.SIGTERM, false)
+	<-time.After(time.Second / 5)
+
+	steps := []struct {
+		trigger bool
+		wait    time.Duration
+		runs    int
+	}{
+		{true, 250 * time.Millisecond, 1},  // the first restart of the burst waits for the delay
+		{false, 300 * time.Millisecond, 2}, // restarted
+		{true, 100 * time.Millisecond, 3},  // within the delay after the restart: restarted immediately
+		{false, 600 * time.Millisecond, 3}, // quiet for the delay: the burst ends
+		{true, 150 * time.Millisecond, 3},  // the first restart of the next burst waits for the delay again
+		{false, 400 * time.Millisecond, 4},
+	}
+	for i, s := range steps {
+		if s.trigger {
+			reload <- trigger{name: "file", op: fsnotify.Write}
+		}
+		<-time.After(s.wait)
+		if b, _ := os.ReadFile(out); len(b) != s.runs {
+			t.Fatalf("step %d: runs = %d, wants %d", i, len(b), s.runs)
+		}
+	}
+}
+
+func TestWatcherDirChangeQueue(t *testing.T) {
+	*dirchg = true
+	*burst = "queue"
+	*watchStdin = true // do not read stdin
+	t.Cleanup(func() {
+		*dirchg = false
+		*burst = "drop"
+		*watchStdin = false
+	})
+
+	tmpdir := t.TempDir()
+	out := path.Join(t.TempDir(), "out")
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	modC, errC, err := watcher([]string{tmpdir}, []string{"**"}, nil, 0)
+	if err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+	reload := runner(ctx, &wg, []string{"sh", "-c", "printf x >> " + out + "; exec sleep 10"}, 0, syscall.SIGTERM, false)
+	go forwardTriggers(ctx, modC, errC, reload)
+
+	// the directory matched to the pattern restarts the command once, not twice.
+	for i, op := range []func() error{
+		func() error { return os.Mkdir(path.Join(tmpdir, "sub"), 0755) },
+		func() error { return os.Remove(path.Join(tmpdir, "sub")) },
+	} {
+		<-time.After(time.Second / 5)
+		if err := op(); err != nil {
+			t.Fatalf("%v: %v", i, err)
+		}
+		<-time.After(time.Second / 2)
+		if b, _ := os.ReadFile(out); len(b) != i+2 {
+			t.Fatalf("%v: runs = %d, wants %d", i, len(b), i+2)
+		}
+	}
+}
+
+func TestReloaderCollectTriggers(t *testing.T) {
+	*burst = "debounce"
+	*minChanged = 2
+	t.Cleanup(func() {
+		*burst = "drop"
+		*minChanged = 0
+	})
+
+	out := path.Join(t.TempDir(), "out")
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	reload := reloader(ctx, &wg, "printf x >> "+out, 300*time.Millisecond, syscall.SIGTERM)
+
+	steps := []struct {
+		t    *trigger
+		wait time.Duration
+		runs int
+	}{
+		{&trigger{name: "a", op: fsnotify.Write}, 400 * time.Millisecond, 0}, // held by --min-changed-files
+		{&trigger{name: "b", op: fsnotify.Write}, 150 * time.Millisecond, 0}, // 2 files changed: reload after the delay
+		{&trigger{name: "interval"}, 200 * time.Millisecond, 0},              // extends the delay on "debounce" policy
+		{nil, 250 * time.Millisecond, 1},
+	}
+	for i, s := range steps {
+		if s.t != nil {
+			reload <- *s.t
+		}
+		<-time.After(s.wait)
+		if b, _ := os.ReadFile(out); len(b) != s.runs {
+			t.Fatalf("step %d: runs = %d, wants %d", i, len(b), s.runs)
+		}
+	}
+}
+
+func TestRunCmdKillTimeout(t *testing.T) {
+	*sigTimeout = time.Second / 5
+	t.Cleanup(func() { *sigTimeout = 5 * time.Second })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Second/5, cancel)
+	start := time.Now()
+	// SIGTERM is ignored, so it is stopped by SIGKILL after the timeout.
+	runCmd(ctx, []string{"sh", "-c", "trap '' TERM; sleep 10"}, syscall.SIGTERM, nil, os.Stdout, os.Stderr)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("runCmd took %v, wants SIGKILL after %v", d, *sigTimeout)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(time.Second/5, cancel)
+	start = time.Now()
+	runReloadCmd(ctx, []string{"sh", "-c", "trap '' TERM; sleep 10"}, syscall.SIGTERM)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("runReloadCmd took %v, wants SIGKILL after %v", d, *sigTimeout)
+	}
+
+	done := make(chan struct{})
+	if err := waitKilled(done, time.Second/10); err == nil {
+		t.Fatalf("waitKilled must be error on timeout")
+	}
+	close(done)
+	if err := waitKilled(done, 0); err != nil {
+		t.Fatalf("waitKilled: %v", err)
+	}
+}
+
+func TestExpandCommand(t *testing.T) {
+	t.Setenv("ARELO_TEST_OUT", "out")
+	tests := []struct {
+		script string
+		expand bool
+		wants  string
+	}{
+		{`for f in a b; do printf "item=$f "; done; echo '$ARELO_TEST_OUT'`, false, "item=a item=b $ARELO_TEST_OUT\n"}, // left for the shell (--no-expand-env)
+		{`for f in a b; do printf "item=$f "; done; echo '$ARELO_TEST_OUT'`, true, "item= item= out\n"},
+		{`for f in a b; do printf "item=$$f "; done; echo '$ARELO_TEST_OUT'`, true, "item=a item=b out\n"}, // escaped for the shell
+	}
+	for _, test := range tests {
+		cmd := []string{"sh", "-c", test.script}
+		expandCommand(cmd, test.expand)
+		out, err := exec.Command(cmd[0], cmd[1:]...).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", cmd, err)
+		}
+		if string(out) != test.wants {
+			t.Fatalf("output of %q (expand=%v) = %q, wants %q", cmd, test.expand, out, test.wants)
+		}
+	}
+}
@@ -18,6 +18,9 @@ const STILL_ACTIVE = 259
 
 var procC chan windows.Handle
 
+// dryRunSignal is not available on Windows.
+var dryRunSignal os.Signal
+
 func parseSignalOption(str string) (os.Signal, string) {
 	if str == "" {
 		return syscall.SIGTERM, "SIGTERM"
@@ -25,6 +28,10 @@ func parseSignalOption(str string) (os.Signal, string) {
 	return nil, "Signal option (--signal, -s) is not available on Windows."
 }
 
+func signalName(sig syscall.Signal) string {
+	return sig.String()
+}
+
 // makeChildDoneChan returns a chan that notifies the child process has exited.
 //
 // On Windows, poll until GetExitCodeProcess() returns anything other than STILL_ACTIVE.
@@ -35,7 +42,7 @@ func makeChildDoneChan() <-chan struct{} {
 		for {
 			p := <-procC
 			for {
-				time.Sleep(*delay / 2)
+				time.Sleep(delay.min / 2)
 				var code uint32
 				err := windows.GetExitCodeProcess(p, &code)
 				if err != nil {
@@ -70,8 +77,52 @@ func waitCmd(cmd *exec.Cmd) error {
 	return cmd.Wait()
 }
 
+func setupCredential(usr, grp string) error {
+	if usr == "" && grp == "" {
+		return nil
+	}
+	return xerrors.New("User and group options (--user, --group) are not available on Windows.")
+}
+
+// newSessionAvailable reports whether --new-session is available.
+const newSessionAvailable = false
+
+// shellCommand returns the command line to run the str by cmd.exe.
+func shellCommand(str string) []string {
+	return []string{"cmd.exe", "/C", str}
+}
+
+// baseEnv is the environment variables retained by --clean-env.
+// The system variables are required to run the most of the programs on Windows.
+var baseEnv = []string{"PATH", "PATHEXT", "SYSTEMROOT", "COMSPEC", "USERPROFILE", "TEMP", "TMP"}
+
 func prepareCommand(cmd []string) *exec.Cmd {
-	return exec.Command(cmd[0], cmd[1:]...)
+	c := exec.Command(cmd[0], cmd[1:]...)
+	c.Env = cmdEnv
+	return c
+}
+
+// setPriority sets the priority class of the command corresponding to the nice value.
+func setPriority(c *exec.Cmd, nice int) error {
+	var class uint32
+	switch {
+	case nice >= 10:
+		class = windows.IDLE_PRIORITY_CLASS
+	case nice > 0:
+		class = windows.BELOW_NORMAL_PRIORITY_CLASS
+	case nice <= -10:
+		class = windows.HIGH_PRIORITY_CLASS
+	case nice < 0:
+		class = windows.ABOVE_NORMAL_PRIORITY_CLASS
+	default:
+		class = windows.NORMAL_PRIORITY_CLASS
+	}
+	p, err := windows.OpenProcess(windows.PROCESS_SET_INFORMATION, false, uint32(c.Process.Pid))
+	if err != nil {
+		return xerrors.Errorf("OpenProcess: %w", err)
+	}
+	defer windows.CloseHandle(p)
+	return windows.SetPriorityClass(p, class)
 }
 
 func killChilds(c *exec.Cmd, sig syscall.Signal) error {
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+// completionFlag is a flag described for the shell completion.
+type completionFlag struct {
+	long, short string
+	arg         string   // name of the argument, "" if the flag has no argument
+	desc        string   // usage without the choices
+	choices     []string // possible values listed in the usage as "(a|b|c)"
+	file        bool     // the argument is a file path
+	repeat      bool     // the flag can be given multiple times
+}
+
+var choicesRe = regexp.MustCompile(` *\(([A-Za-z0-9-]+(?:\|[A-Za-z0-9-]+)+)\)`)
+
+// completionFlags collects the flags registered to the flag set.
+func completionFlags(fs *pflag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *pflag.Flag) {
+		arg, desc := pflag.UnquoteUsage(f)
+		if f.NoOptDefVal != "" {
+			// the argument is optional (e.g. count)
+			arg = ""
+		}
+		cf := completionFlag{
+			long:   f.Name,
+			short:  f.Shorthand,
+			arg:    arg,
+			desc:   desc,
+			file:   arg == "path" || arg == "file",
+			repeat: strings.HasSuffix(f.Value.Type(), "Array"),
+		}
+		if m := choicesRe.FindStringSubmatch(desc); m != nil {
+			cf.choices = strings.Split(m[1], "|")
+			cf.desc = strings.Replace(desc, m[0], "", 1)
+		}
+		flags = append(flags, cf)
+	})
+	return flags
+}
+
+// completion writes the completion script of the shell to w.
+func completion(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return xerrors.New("usage: arelo completion bash|zsh|fish")
+	}
+	flags := completionFlags(pflag.CommandLine)
+	switch args[0] {
+	case "bash":
+		bashCompletion(w, flags)
+	case "zsh":
+		zshCompletion(w, flags)
+	case "fish":
+		fishCompletion(w, flags)
+	default:
+		return xerrors.Errorf("unsupported shell: %s", args[0])
+	}
+	return nil
+}
+
+func bashCompletion(w io.Writer, flags []completionFlag) {
+	var all, files, others []string
+	var choices []string
+	for _, f := range flags {
+		names := []string{"--" + f.long}
+		if f.short != "" {
+			names = append(names, "-"+f.short)
+		}
+		all = append(all, names...)
+		switch {
+		case f.arg == "":
+		case f.choices != nil:
+			choices = append(choices, fmt.Sprintf("\t\t%s)\n\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\t\treturn\n\t\t\t;;\n",
+				strings.Join(names, "|"), strings.Join(f.choices, " ")))
+		case f.file:
+			files = append(files, names...)
+		default:
+			others = append(others, names...)
+		}
+	}
+
+	fmt.Fprintf(w, "# bash completion for arelo\n")
+	fmt.Fprintf(w, "_arelo() {\n")
+	fmt.Fprintf(w, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\" i\n")
+	fmt.Fprintf(w, "\tfor ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(w, "\t\tif [[ \"${COMP_WORDS[i]}\" == \"--\" ]]; then\n")
+	fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -c -- \"$cur\"))\n")
+	fmt.Fprintf(w, "\t\t\treturn\n")
+	fmt.Fprintf(w, "\t\tfi\n")
+	fmt.Fprintf(w, "\tdone\n")
+	fmt.Fprintf(w, "\tcase \"$prev\" in\n")
+	for _, c := range choices {
+		fmt.Fprint(w, c)
+	}
+	fmt.Fprintf(w, "\t\t%s)\n\t\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n\t\t\treturn\n\t\t\t;;\n", strings.Join(files, "|"))
+	fmt.Fprintf(w, "\t\t%s)\n\t\t\treturn\n\t\t\t;;\n", strings.Join(others, "|"))
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(all, " "))
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -F _arelo arelo\n")
+}
+
+func zshCompletion(w io.Writer, flags []completionFlag) {
+	esc := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`)
+
+	fmt.Fprintf(w, "#compdef arelo\n\n")
+	fmt.Fprintf(w, "_arguments -s -S \\\n")
+	for _, f := range flags {
+		spec := "--" + f.long
+		if f.short != "" {
+			spec = fmt.Sprintf("{-%s,--%s}", f.short, f.long)
+		}
+		if f.repeat {
+			spec = "'*'" + spec
+		}
+		spec += "'[" + esc.Replace(f.desc) + "]"
+		switch {
+		case f.arg == "":
+		case f.choices != nil:
+			spec += ":" + f.arg + ":(" + strings.Join(f.choices, " ") + ")"
+		case f.file:
+			spec += ":" + f.arg + ":_files"
+		default:
+			spec += ":" + f.arg + ": "
+		}
+		fmt.Fprintf(w, "\t%s' \\\n", spec)
+	}
+	fmt.Fprintf(w, "\t'*::command:_normal'\n")
+}
+
+func fishCompletion(w io.Writer, flags []completionFlag) {
+	esc := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+
+	fmt.Fprintf(w, "# fish completion for arelo\n")
+	for _, f := range flags {
+		line := "complete -c arelo"
+		if f.short != "" {
+			line += " -s " + f.short
+		}
+		line += " -l " + f.long
+		switch {
+		case f.arg == "":
+		case f.choices != nil:
+			line += " -x -a '" + strings.Join(f.choices, " ") + "'"
+		case f.file:
+			line += " -r -F"
+		default:
+			line += " -x"
+		}
+		line += " -d '" + esc.Replace(f.desc) + "'"
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestCompletionFlags(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.StringArrayP("target", "t", nil, "observation target `path`")
+	fs.String("burst-policy", "drop", "`policy` for the triggers (drop|debounce|queue)")
+	fs.Bool("verbose", false, "verbose output")
+
+	flags := completionFlags(fs)
+	wants := []completionFlag{
+		{long: "burst-policy", arg: "policy", desc: "policy for the triggers", choices: []string{"drop", "debounce", "queue"}},
+		{long: "target", short: "t", arg: "path", desc: "observation target path", file: true, repeat: true},
+		{long: "verbose", desc: "verbose output"},
+	}
+	if !reflect.DeepEqual(flags, wants) {
+		t.Fatalf("completionFlags:\n%#v\nwants:\n%#v", flags, wants)
+	}
+}
+
+func TestCompletionChoices(t *testing.T) {
+	wants := map[string][]string{
+		"burst-policy": {"drop", "debounce", "queue"},
+		"watch-mode":   {"fsnotify", "fanotify", "git-status", "none"},
+		"on-chmod":     {"any", "exec"},
+	}
+	found := 0
+	for _, f := range completionFlags(pflag.CommandLine) {
+		w, ok := wants[f.long]
+		if !ok {
+			continue
+		}
+		found++
+		if !reflect.DeepEqual(f.choices, w) {
+			t.Fatalf("choices of --%s = %q, wants %q", f.long, f.choices, w)
+		}
+	}
+	if found != len(wants) {
+		t.Fatalf("flags found: %v, wants %v", found, len(wants))
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	for _, sh := range []string{"bash", "zsh", "fish"} {
+		var b bytes.Buffer
+		if err := completion(&b, []string{sh}); err != nil {
+			t.Fatalf("completion(%s): %v", sh, err)
+		}
+		if !strings.Contains(b.String(), "target") {
+			t.Fatalf("completion(%s) does not contain target:\n%s", sh, b.String())
+		}
+	}
+	if err := completion(&bytes.Buffer{}, []string{"tcsh"}); err == nil {
+		t.Fatalf("completion(tcsh) must be error")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+// defaultConfigFile is read when it exists in the current directory and --config is not given.
+const defaultConfigFile = ".arelo.yaml"
+
+// configOption is a key of the config file and the option set by it.
+type configOption struct {
+	key   string
+	flag  string   // option set by the key
+	given []string // options on the command line or the environment variables which override the key
+}
+
+// configValue is the value of a key in the config file.
+type configValue struct {
+	items []string
+	list  bool // given as a sequence
+}
+
+// configOptions is the keys of the config file.
+var configOptions = []configOption{
+	{"targets", "target", []string{"target", "targets"}},
+	{"patterns", "pattern", []string{"pattern", "patterns"}},
+	{"ignores", "ignore", []string{"ignore"}},
+	{"delay", "delay", []string{"delay"}},
+	{"signal", "signal", []string{"signal"}},
+	{"restart", "restart-on-exit", []string{"restart-on-exit"}},
+	{"command", "cmd", []string{"cmd"}},
+}
+
+// readConfigFile reads the config file.
+// The default config file is optional, so it returns nil without error when it does not exist.
+func readConfigFile(file string, optional bool) (map[string]configValue, error) {
+	f, err := os.Open(file)
+	if optional && xerrors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("config: %w", err)
+	}
+	defer f.Close()
+	cfg, err := parseConfig(f)
+	if err != nil {
+		return nil, xerrors.Errorf("config %q: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// parseConfig parses the config file written in the small subset of YAML:
+//
+//	key: value
+//	key: [value, value]
+//	key:
+//	  - value
+//	  - value
+//
+// The values can be quoted by the single or double quotes, and the comments start with "#".
+func parseConfig(r io.Reader) (map[string]configValue, error) {
+	cfg := make(map[string]configValue)
+	var list string // key of the block sequence being read
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(stripComment(s.Text()), " \t")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if item, ok := strings.CutPrefix(strings.TrimLeft(line, " \t"), "-"); ok && list != "" && (item == "" || item[0] == ' ') {
+			v, err := unquote(strings.TrimSpace(item))
+			if err != nil {
+				return nil, xerrors.Errorf("line %d: %w", n, err)
+			}
+			cfg[list] = configValue{append(cfg[list].items, v), true}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, xerrors.Errorf("line %d: unexpected indent", n)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, xerrors.Errorf("line %d: not a \"key: value\"", n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if _, ok := cfg[key]; ok {
+			return nil, xerrors.Errorf("line %d: duplicated key: %s", n, key)
+		}
+		list = ""
+		switch {
+		case value == "":
+			list = key
+			cfg[key] = configValue{[]string{}, true}
+		case value[0] == '[':
+			if value[len(value)-1] != ']' {
+				return nil, xerrors.Errorf("line %d: unterminated list", n)
+			}
+			items, err := splitFlowList(value[1 : len(value)-1])
+			if err != nil {
+				return nil, xerrors.Errorf("line %d: %w", n, err)
+			}
+			cfg[key] = configValue{items, true}
+		default:
+			v, err := unquote(value)
+			if err != nil {
+				return nil, xerrors.Errorf("line %d: %w", n, err)
+			}
+			cfg[key] = configValue{[]string{v}, false}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// stripComment removes the comment which starts with "#" at the beginning or after a space, outside the quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitFlowList splits the items of the flow sequence "[a, b]" by the commas outside the quotes and the braces.
+func splitFlowList(s string) ([]string, error) {
+	items := []string{}
+	var quote byte
+	depth, start := 0, 0
+	add := func(item string) error {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil
+		}
+		v, err := unquote(item)
+		if err != nil {
+			return err
+		}
+		items = append(items, v)
+		return nil
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			if err := add(s[start:i]); err != nil {
+				return nil, err
+			}
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, xerrors.Errorf("unterminated quote: %c", quote)
+	}
+	if err := add(s[start:]); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// unquote removes the quotes of the scalar value.
+func unquote(v string) (string, error) {
+	if v == "" {
+		return v, nil
+	}
+	switch v[0] {
+	case '"':
+		return strconv.Unquote(v)
+	case '\'':
+		if len(v) < 2 || v[len(v)-1] != '\'' {
+			return "", xerrors.Errorf("unterminated quote: %s", v)
+		}
+		return strings.ReplaceAll(v[1:len(v)-1], "''", "'"), nil
+	}
+	return v, nil
+}
+
+// applyConfig sets the options not given by the command line or the environment variables from the config.
+// It returns the options set, to be placed before the ones on the command line.
+func applyConfig(fs *pflag.FlagSet, cfg map[string]configValue, given func(string) bool) ([]optArg, error) {
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !slices.ContainsFunc(configOptions, func(o configOption) bool { return o.key == k }) {
+			return nil, xerrors.Errorf("unknown key: %s", k)
+		}
+	}
+
+	var args []optArg
+	for _, o := range configOptions {
+		v, ok := cfg[o.key]
+		if !ok || slices.ContainsFunc(o.given, given) {
+			continue
+		}
+		values := v.items
+		if o.key == "command" && v.list {
+			// the command line of the list is given to --cmd with each argument quoted.
+			q := make([]string, len(values))
+			for i, v := range values {
+				q[i] = "'" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+			}
+			values = []string{strings.Join(q, " ")}
+		}
+		if !v.list && len(values) > 0 && strings.HasSuffix(fs.Lookup(o.flag).Value.Type(), "Array") {
+			// a scalar of the list option is the list separated by the commas, like the environment variables.
+			values = splitList(values[0], ",")
+		}
+		for _, v := range values {
+			if err := fs.Set(o.flag, v); err != nil {
+				return nil, xerrors.Errorf("%s: %w", o.key, err)
+			}
+			args = append(args, optArg{o.flag, v})
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func TestParseConfig(t *testing.T) {
+	src := `# arelo config
+targets:
+  - src
+  - 'lib' # comment
+patterns: ['**/*.{go,mod}', "**/*.html"]
+ignores: "**/#*"
+delay: 2s
+empty:
+command: [sh, -c, "echo 'hi'"]
+`
+	cfg, err := parseConfig(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	wants := map[string]configValue{
+		"targets":  {[]string{"src", "lib"}, true},
+		"patterns": {[]string{"**/*.{go,mod}", "**/*.html"}, true},
+		"ignores":  {[]string{"**/#*"}, false},
+		"delay":    {[]string{"2s"}, false},
+		"empty":    {[]string{}, true},
+		"command":  {[]string{"sh", "-c", "echo 'hi'"}, true},
+	}
+	if !reflect.DeepEqual(cfg, wants) {
+		t.Fatalf("parseConfig:\n%v\nwants:\n%v", cfg, wants)
+	}
+
+	for _, src := range []string{
+		"  delay: 1s\n",
+		"delay 1s\n",
+		"delay: 1s\ndelay: 2s\n",
+		"patterns: ['a', 'b'\n",
+		"patterns: ['a, b]\n",
+		"command: \"sh\n",
+	} {
+		if _, err := parseConfig(strings.NewReader(src)); err == nil {
+			t.Fatalf("parseConfig(%q) must be error", src)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	targets := fs.StringArrayP("target", "t", nil, "")
+	fs.StringArray("targets", nil, "")
+	patterns := fs.StringArrayP("pattern", "p", nil, "")
+	fs.StringArray("patterns", nil, "")
+	ignores := fs.StringArrayP("ignore", "i", nil, "")
+	delay := fs.DurationP("delay", "d", time.Second, "")
+	fs.StringP("signal", "s", "", "")
+	restart := fs.BoolP("restart-on-exit", "r", false, "")
+	cmd := fs.String("cmd", "", "")
+	if err := fs.Parse([]string{"-d", "3s"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	cfg := map[string]configValue{
+		"targets":  {[]string{"src, lib"}, false},
+		"patterns": {[]string{"**/*.{go,mod}"}, true},
+		"ignores":  {[]string{}, true},
+		"delay":    {[]string{"2s"}, false},
+		"restart":  {[]string{"true"}, false},
+		"command":  {[]string{"sh", "-c", "echo 'hi'"}, true},
+	}
+	args, err := applyConfig(fs, cfg, fs.Changed)
+	if err != nil {
+		t.Fatalf("applyConfig: %v", err)
+	}
+	if !reflect.DeepEqual(*targets, []string{"src", "lib"}) {
+		t.Fatalf("targets = %q, wants %q", *targets, []string{"src", "lib"})
+	}
+	if !reflect.DeepEqual(*patterns, []string{"**/*.{go,mod}"}) {
+		t.Fatalf("patterns = %q, wants %q", *patterns, []string{"**/*.{go,mod}"})
+	}
+	if *ignores != nil {
+		t.Fatalf("ignores = %q, wants nil", *ignores)
+	}
+	if *delay != 3*time.Second || !*restart { // delay is overridden by the command line
+		t.Fatalf("delay, restart = %v, %v, wants 3s, true", *delay, *restart)
+	}
+	if c, err := splitCommand(*cmd); err != nil || !reflect.DeepEqual(c, []string{"sh", "-c", "echo 'hi'"}) {
+		t.Fatalf("cmd = %q (%q, %v)", *cmd, c, err)
+	}
+	if len(args) != 5 || args[0] != (optArg{"target", "src"}) {
+		t.Fatalf("args = %v", args)
+	}
+
+	if _, err := applyConfig(fs, map[string]configValue{"foo": {[]string{"bar"}, false}}, fs.Changed); err == nil {
+		t.Fatalf("applyConfig must be error for the unknown key")
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	file := path.Join(t.TempDir(), ".arelo.yaml")
+	if cfg, err := readConfigFile(file, true); cfg != nil || err != nil {
+		t.Fatalf("readConfigFile(optional) = %v, %v, wants nil, nil", cfg, err)
+	}
+	if _, err := readConfigFile(file, false); err == nil {
+		t.Fatalf("readConfigFile must be error for the missing file")
+	}
+	if err := os.WriteFile(file, []byte("delay: 1s\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if cfg, err := readConfigFile(file, true); err != nil || cfg["delay"].items[0] != "1s" {
+		t.Fatalf("readConfigFile = %v, %v", cfg, err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+const sizeofFanotifyEventMetadata = int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+
+const fanotifyMask = unix.FAN_CREATE | unix.FAN_DELETE | unix.FAN_MOVED_FROM | unix.FAN_MOVED_TO |
+	unix.FAN_MODIFY | unix.FAN_ATTRIB | unix.FAN_ONDIR
+
+// fanotifyWatcher watches the whole filesystems of the added paths by fanotify (--watch-mode=fanotify),
+// and reports the events only of the added paths like fsnotify.
+//
+// A filesystem is marked only once, so it is not limited by max_user_watches of inotify,
+// but it requires CAP_SYS_ADMIN and Linux 5.9 or later.
+//
+// When access is true, the read access to the files is reported as opAccess (--on-access),
+// except the access by arelo itself not to trigger by reading the files (e.g. content match).
+type fanotifyWatcher struct {
+	fd     int
+	mask   uint64
+	f      *os.File // the fd wrapped to read in the poller, its Fd() must not be called
+	events chan fsnotify.Event
+	errors chan error // buffered, the reader returns after sending an error
+	done   chan struct{}
+
+	mu     sync.Mutex
+	marked map[[2]int32]bool // fsid of the marked filesystems
+	dirs   map[string]string // file handle of the directory -> name
+	files  map[string]string // file handle of the parent directory + "/" + base name -> name
+	keys   map[string]string // name -> key of dirs or files
+}
+
+func newFanotifyWatcher(access bool) (fsWatcher, <-chan fsnotify.Event, <-chan error, error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_REPORT_DFID_NAME|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK, unix.O_RDONLY)
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("fanotify init: %w", err)
+	}
+	mask := uint64(fanotifyMask)
+	if access {
+		mask |= unix.FAN_ACCESS
+	}
+	w := &fanotifyWatcher{
+		fd:     fd,
+		mask:   mask,
+		f:      os.NewFile(uintptr(fd), "fanotify"),
+		events: make(chan fsnotify.Event),
+		errors: make(chan error, 1),
+		done:   make(chan struct{}),
+		marked: make(map[[2]int32]bool),
+		dirs:   make(map[string]string),
+		files:  make(map[string]string),
+		keys:   make(map[string]string),
+	}
+	go w.readEvents()
+	return w, w.events, w.errors, nil
+}
+
+// handleKey returns the key of the file handle on the filesystem.
+func handleKey(fsid [2]int32, typ int32, handle []byte) string {
+	b := make([]byte, 12, 12+len(handle))
+	binary.NativeEndian.PutUint32(b[0:], uint32(fsid[0]))
+	binary.NativeEndian.PutUint32(b[4:], uint32(fsid[1]))
+	binary.NativeEndian.PutUint32(b[8:], uint32(typ))
+	return string(append(b, handle...))
+}
+
+// Add marks the filesystem of the name if not yet, and starts reporting the events of the name.
+func (w *fanotifyWatcher) Add(name string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return xerrors.Errorf("abs: %w", err)
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(abs, &st); err != nil {
+		return xerrors.Errorf("statfs %q: %w", name, err)
+	}
+	fsid := st.Fsid.Val
+
+	// the events of the file are reported with the handle of its parent directory.
+	dir := abs
+	if !fi.IsDir() {
+		dir = filepath.Dir(abs)
+	}
+	h, _, err := unix.NameToHandleAt(unix.AT_FDCWD, dir, 0)
+	if err != nil {
+		return xerrors.Errorf("name to handle %q: %w", dir, err)
+	}
+	key := handleKey(fsid, h.Type(), h.Bytes())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.marked[fsid] {
+		err := unix.FanotifyMark(w.fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, w.mask, unix.AT_FDCWD, abs)
+		if err != nil {
+			return xerrors.Errorf("fanotify mark %q: %w", name, err)
+		}
+		w.marked[fsid] = true
+	}
+	name = filepath.ToSlash(name)
+	if fi.IsDir() {
+		w.dirs[key] = name
+	} else {
+		key += "/" + filepath.Base(abs)
+		w.files[key] = name
+	}
+	w.keys[path.Clean(name)] = key
+	return nil
+}
+
+// Remove stops reporting the events of the name.
+func (w *fanotifyWatcher) Remove(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	name = path.Clean(filepath.ToSlash(name))
+	k, ok := w.keys[name]
+	if !ok {
+		return fsnotify.ErrNonExistentWatch
+	}
+	delete(w.dirs, k)
+	delete(w.files, k)
+	delete(w.keys, name)
+	return nil
+}
+
+func (w *fanotifyWatcher) Close() error {
+	close(w.done)
+	return w.f.Close()
+}
+
+func (w *fanotifyWatcher) readEvents() {
+	defer close(w.events)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.f.Read(buf)
+		if xerrors.Is(err, os.ErrClosed) {
+			return
+		} else if err != nil {
+			w.errors <- xerrors.Errorf("fanotify read: %w", err)
+			return
+		}
+		for b := buf[:n]; len(b) >= sizeofFanotifyEventMetadata; {
+			l := binary.NativeEndian.Uint32(b[0:])
+			if int(l) < sizeofFanotifyEventMetadata || int(l) > len(b) {
+				break
+			}
+			ev, err := w.parseEvent(b[:l])
+			if err != nil {
+				w.errors <- err
+				return
+			}
+			if ev.Name != "" {
+				select {
+				case w.events <- ev:
+				case <-w.done:
+					return
+				}
+			}
+			b = b[l:]
+		}
+	}
+}
+
+// parseEvent converts the fanotify event to the fsnotify event.
+// It returns the event without the name if the event is not of the added paths.
+func (w *fanotifyWatcher) parseEvent(b []byte) (fsnotify.Event, error) {
+	mask := binary.NativeEndian.Uint64(b[8:])
+	if mask&unix.FAN_Q_OVERFLOW != 0 {
+		return fsnotify.Event{}, fsnotify.ErrEventOverflow
+	}
+	if mask == unix.FAN_ACCESS && int(int32(binary.NativeEndian.Uint32(b[20:]))) == os.Getpid() {
+		return fsnotify.Event{}, nil // read by arelo itself
+	}
+
+	// info record: header(4), fsid(8), handle_bytes(4), handle_type(4), f_handle, name\0
+	info := b[binary.NativeEndian.Uint16(b[6:]):]
+	if len(info) < 20 || info[0] != unix.FAN_EVENT_INFO_TYPE_DFID_NAME {
+		return fsnotify.Event{}, nil
+	}
+	info = info[:binary.NativeEndian.Uint16(info[2:])]
+	fsid := [2]int32{int32(binary.NativeEndian.Uint32(info[4:])), int32(binary.NativeEndian.Uint32(info[8:]))}
+	hlen := int(binary.NativeEndian.Uint32(info[12:]))
+	typ := int32(binary.NativeEndian.Uint32(info[16:]))
+	if len(info) < 20+hlen {
+		return fsnotify.Event{}, nil
+	}
+	key := handleKey(fsid, typ, info[20:20+hlen])
+	base := info[20+hlen:]
+	for i, c := range base {
+		if c == 0 {
+			base = base[:i]
+			break
+		}
+	}
+
+	var op fsnotify.Op
+	if mask&(unix.FAN_CREATE|unix.FAN_MOVED_TO) != 0 {
+		op |= fsnotify.Create
+	}
+	if mask&unix.FAN_MODIFY != 0 {
+		op |= fsnotify.Write
+	}
+	if mask&unix.FAN_DELETE != 0 {
+		op |= fsnotify.Remove
+	}
+	if mask&unix.FAN_MOVED_FROM != 0 {
+		op |= fsnotify.Rename
+	}
+	if mask&unix.FAN_ATTRIB != 0 {
+		op |= fsnotify.Chmod
+	}
+	if mask&unix.FAN_ACCESS != 0 {
+		op |= opAccess
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var name string
+	if d, ok := w.dirs[key]; ok {
+		name = path.Join(d, string(base))
+	} else if f, ok := w.files[key+"/"+string(base)]; ok {
+		name = f
+	} else {
+		return fsnotify.Event{}, nil
+	}
+	if op.Has(fsnotify.Remove) || op.Has(fsnotify.Rename) {
+		// stop watching like inotify.
+		if k, ok := w.keys[path.Clean(name)]; ok {
+			delete(w.dirs, k)
+			delete(w.files, k)
+			delete(w.keys, path.Clean(name))
+		}
+	}
+	return fsnotify.Event{Name: name, Op: op}, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const hookTimeout = 5 * time.Second
+
+// hookEvent is the payload posted to the --restart-hook-url.
+type hookEvent struct {
+	Event   string    `json:"event"` // "restart", "exit", or "watch" and "unwatch" (--hook-watch-set)
+	Command string    `json:"command,omitempty"`
+	Path    string    `json:"path,omitempty"` // added to or removed from the watch set
+	Trigger string    `json:"trigger,omitempty"`
+	Pattern string    `json:"pattern,omitempty"`
+	Status  string    `json:"status,omitempty"` // exit status of the command on "exit"
+	Time    time.Time `json:"time"`
+}
+
+// postHook posts the event to the url in background.
+// It does not block the caller, and the failures are logged only in verbose mode.
+func postHook(url string, ev hookEvent) {
+	if url == "" {
+		return
+	}
+	go func() {
+		if err := sendHook(url, ev); err != nil {
+			logVerbose("hook: %v", err)
+		}
+	}()
+}
+
+func sendHook(url string, ev hookEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return xerrors.Errorf("marshal: %w", err)
+	}
+	c := http.Client{Timeout: hookTimeout}
+	res, err := c.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return xerrors.Errorf("post: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return xerrors.Errorf("post: %s", res.Status)
+	}
+	return nil
+}
+
+// hookWatcher posts the paths added to or removed from the watch set (--hook-watch-set).
+// It wraps the backend after the targets are added, so only the changes at runtime are posted.
+type hookWatcher struct {
+	fsWatcher
+	url string
+}
+
+func (w hookWatcher) Add(name string) error {
+	err := w.fsWatcher.Add(name)
+	if err == nil {
+		postHook(w.url, hookEvent{Event: "watch", Path: name, Time: time.Now()})
+	}
+	return err
+}
+
+// Remove posts the removal even if the backend has already removed the watch (e.g. the directory is removed).
+func (w hookWatcher) Remove(name string) error {
+	err := w.fsWatcher.Remove(name)
+	postHook(w.url, hookEvent{Event: "unwatch", Path: name, Time: time.Now()})
+	return err
+}
+
+// errStatus returns the exit status of the command from the error of runCmd.
+func errStatus(err error) string {
+	if err == nil {
+		return "code=0"
+	}
+	var ee *exec.ExitError
+	if xerrors.As(err, &ee) {
+		return exitStatus(ee.ProcessState)
+	}
+	return err.Error()
+}
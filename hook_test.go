@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestPostHook(t *testing.T) {
+	evC := make(chan hookEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev hookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		evC <- ev
+	}))
+	t.Cleanup(srv.Close)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	wants := hookEvent{Event: "restart", Command: "go run .", Trigger: "main.go", Pattern: "**/*.go", Time: now}
+	postHook(srv.URL, wants)
+	select {
+	case ev := <-evC:
+		if ev != wants {
+			t.Fatalf("posted %#v, wants %#v", ev, wants)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("hook is not posted")
+	}
+
+	if err := sendHook(srv.URL+"/\x00", wants); err == nil {
+		t.Fatalf("sendHook must be error")
+	}
+}
+
+func TestHookWatchSet(t *testing.T) {
+	evC := make(chan hookEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev hookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		evC <- ev
+	}))
+	t.Cleanup(srv.Close)
+	*hookURL = srv.URL
+	*hookWatchSet = true
+	t.Cleanup(func() {
+		*hookURL = ""
+		*hookWatchSet = false
+	})
+
+	tmpdir := t.TempDir()
+	sub := path.Join(tmpdir, "sub")
+	if _, _, err := watcher([]string{tmpdir}, []string{"**/file"}, nil, 0); err != nil {
+		t.Fatalf("watcher: %v", err)
+	}
+
+	// the targets watched at the start are not posted.
+	select {
+	case ev := <-evC:
+		t.Fatalf("unexpected hook: %#v", ev)
+	case <-time.After(time.Second / 5):
+	}
+
+	tests := []struct {
+		op    func() error
+		event string
+	}{
+		{func() error { return os.Mkdir(sub, 0755) }, "watch"},
+		{func() error { return os.Remove(sub) }, "unwatch"},
+	}
+	for _, test := range tests {
+		if err := test.op(); err != nil {
+			t.Fatalf("%v: %v", test.event, err)
+		}
+		select {
+		case ev := <-evC:
+			if ev.Event != test.event || ev.Path != sub {
+				t.Fatalf("posted %q %q, wants %q %q", ev.Event, ev.Path, test.event, sub)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v is not posted", test.event)
+		}
+	}
+}
+
+func TestErrStatus(t *testing.T) {
+	if s := errStatus(nil); s != "code=0" {
+		t.Fatalf("errStatus(nil) = %q", s)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// logTimeLayout is the timestamp prefixed by the log package with log.LstdFlags.
+const logTimeLayout = "2006/01/02 15:04:05 "
+
+var byteSizeRe = regexp.MustCompile(`^([0-9]+)\s*([A-Za-z]*)$`)
+
+// parseByteSize parses the size such as "10MB".
+func parseByteSize(s string) (int64, error) {
+	m := byteSizeRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, xerrors.Errorf("invalid size: %s", s)
+	}
+	unit, ok := sizeUnits[strings.ToLower(m[2])]
+	if !ok {
+		return 0, xerrors.Errorf("invalid size unit: %s", m[2])
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, xerrors.Errorf("invalid size: %w", err)
+	}
+	return n * unit, nil
+}
+
+// rotateFile is a file rotated when its size exceeds maxSize.
+// The old files are renamed to "path.1", "path.2", ... and at most keep files are retained.
+type rotateFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+	failed  bool // the rotation error is already reported
+}
+
+func openRotateFile(path string, maxSize int64, keep int) (*rotateFile, error) {
+	r := &rotateFile{path: path, maxSize: maxSize, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotateFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return xerrors.Errorf("open log file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return xerrors.Errorf("stat log file: %w", err)
+	}
+	r.f = f
+	r.size = fi.Size()
+	return nil
+}
+
+// rotate renames the current file and opens the new one.
+// When the rename fails, the current file is opened again to keep the log in it.
+func (r *rotateFile) rotate() error {
+	r.f.Close()
+	var err error
+	if r.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.keep))
+		for i := r.keep - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		err = os.Rename(r.path, r.path+".1")
+	} else {
+		err = os.Remove(r.path)
+	}
+	if oerr := r.open(); oerr != nil {
+		r.f = nil
+		return oerr
+	}
+	if err != nil {
+		return xerrors.Errorf("rotate log file: %w", err)
+	}
+	return nil
+}
+
+// reportError reports the error of the log file once to the stderr, not by the log package which calls Write.
+func (r *rotateFile) reportError(err error) {
+	if !r.failed {
+		fmt.Fprintf(os.Stderr, "%s[ARELO] warning: %v\n", time.Now().Format(logTimeLayout), err)
+		r.failed = true
+	}
+}
+
+func (r *rotateFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		// the file could not be opened on the last rotation.
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			r.reportError(err)
+			if r.f == nil {
+				return 0, err
+			}
+		} else {
+			r.failed = false
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotateFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+// jsonLogEntry is a line of the --log-json-file.
+type jsonLogEntry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// jsonLogWriter converts each line written by the log package into a JSON line.
+type jsonLogWriter struct {
+	w io.Writer
+}
+
+func (j jsonLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	ts := time.Now()
+	if len(msg) >= len(logTimeLayout) {
+		if t, err := time.ParseInLocation(logTimeLayout, msg[:len(logTimeLayout)], time.Local); err == nil {
+			ts = t
+			msg = msg[len(logTimeLayout):]
+		}
+	}
+	msg = strings.TrimPrefix(msg, "[ARELO] ")
+	b, err := json.Marshal(jsonLogEntry{Time: ts, Message: msg})
+	if err != nil {
+		return 0, xerrors.Errorf("marshal log: %w", err)
+	}
+	if _, err := j.w.Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		s     string
+		wants int64
+		err   bool
+	}{
+		{"100", 100, false},
+		{"10MB", 10 << 20, false},
+		{"2 k", 2 << 10, false},
+		{"1GiB", 1 << 30, false},
+		{"10XB", 0, true},
+		{"-1", 0, true},
+		{"", 0, true},
+	}
+	for _, test := range tests {
+		n, err := parseByteSize(test.s)
+		if test.err {
+			if err == nil {
+				t.Fatalf("parseByteSize(%q) must be error", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parseByteSize(%q): %v", test.s, err)
+		}
+		if n != test.wants {
+			t.Fatalf("parseByteSize(%q) = %v wants %v", test.s, n, test.wants)
+		}
+	}
+}
+
+func TestRotateFile(t *testing.T) {
+	file := path.Join(t.TempDir(), "arelo.log")
+	r, err := openRotateFile(file, 10, 2)
+	if err != nil {
+		t.Fatalf("openRotateFile: %v", err)
+	}
+	defer r.Close()
+
+	for i := 0; i < 4; i++ {
+		if _, err := fmt.Fprintf(r, "line%d\n", i); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	tests := []struct {
+		file  string
+		wants string
+	}{
+		{file, "line3\n"},
+		{file + ".1", "line2\n"},
+		{file + ".2", "line1\n"},
+	}
+	for _, test := range tests {
+		b, err := os.ReadFile(test.file)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if string(b) != test.wants {
+			t.Fatalf("%q = %q wants %q", test.file, b, test.wants)
+		}
+	}
+	if _, err := os.Stat(file + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("%q must be removed: %v", file+".3", err)
+	}
+}
+
+func TestRotateFileFailed(t *testing.T) {
+	file := path.Join(t.TempDir(), "arelo.log")
+	// the file cannot be renamed to the non-empty directory.
+	if err := os.MkdirAll(path.Join(file+".1", "dir"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	r, err := openRotateFile(file, 10, 1)
+	if err != nil {
+		t.Fatalf("openRotateFile: %v", err)
+	}
+	defer r.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := fmt.Fprintf(r, "line%d\n", i); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// kept in the current file.
+	if b, _ := os.ReadFile(file); string(b) != "line0\nline1\nline2\n" {
+		t.Fatalf("%q = %q wants %q", file, b, "line0\nline1\nline2\n")
+	}
+}
+
+func TestJSONLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := jsonLogWriter{&buf}
+	if _, err := w.Write([]byte("2024/01/02 03:04:05 [ARELO] start: [\"go\" \"run\"]\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var e jsonLogEntry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	wants := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	if !e.Time.Equal(wants) {
+		t.Fatalf("time = %v wants %v", e.Time, wants)
+	}
+	if e.Message != `start: ["go" "run"]` {
+		t.Fatalf("message = %q", e.Message)
+	}
+}
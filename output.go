@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// teeCheckInterval is the interval to check whether the output file is rotated.
+const teeCheckInterval = time.Second
+
+// teeFile is the file to write the output of the command in addition to the terminal (--output-file).
+// It is reopened when the file is moved or removed externally (e.g. by logrotate).
+type teeFile struct {
+	mu      sync.Mutex
+	name    string
+	f       *os.File
+	fi      os.FileInfo
+	checked time.Time // last time the rotation is checked
+	failed  bool      // the write error is already reported
+}
+
+func openTeeFile(name string, appendMode bool) (*teeFile, error) {
+	o := &teeFile{name: name}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	if err := o.open(flag); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *teeFile) open(flag int) error {
+	f, err := os.OpenFile(o.name, flag, 0644)
+	if err != nil {
+		return xerrors.Errorf("open output file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return xerrors.Errorf("stat output file: %w", err)
+	}
+	o.f, o.fi, o.checked = f, fi, time.Now()
+	return nil
+}
+
+// Write writes p to the file.
+// It never fails not to break the output of the command to the terminal,
+// the error is logged only once.
+// The rotation is checked every teeCheckInterval and after a write error, not on each write.
+func (o *teeFile) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if time.Since(o.checked) >= teeCheckInterval {
+		o.checked = time.Now()
+		if fi, err := os.Stat(o.name); err != nil || o.f == nil || !os.SameFile(fi, o.fi) {
+			if o.f != nil {
+				o.f.Close()
+			}
+			logVerbose("output file %q is rotated, reopen", o.name)
+			if err := o.open(os.O_WRONLY | os.O_CREATE | os.O_APPEND); err != nil {
+				o.f = nil
+				o.reportError(err)
+				return len(p), nil
+			}
+		}
+	}
+	if o.f == nil {
+		return len(p), nil
+	}
+	if _, err := o.f.Write(p); err != nil {
+		o.reportError(err)
+		o.checked = time.Time{} // check the rotation at the next write
+	}
+	return len(p), nil
+}
+
+func (o *teeFile) reportError(err error) {
+	if !o.failed {
+		log.Printf("[ARELO] warning: cannot write the output to %q: %v", o.name, err)
+		o.failed = true
+	}
+}
+
+func (o *teeFile) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.f == nil {
+		return nil
+	}
+	return o.f.Close()
+}
+
+// openOutputs returns the writers of the stdout and stderr of the command,
+// which write to the outFile and errFile too.
+// When errFile is "", the stderr is written to the outFile.
+func openOutputs(outFile, errFile string, appendMode bool) (stdout, stderr io.Writer, closer func(), err error) {
+	stdout, stderr = os.Stdout, os.Stderr
+	var files []*teeFile
+	closer = func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	if outFile != "" {
+		o, err := openTeeFile(outFile, appendMode)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, o)
+		stdout = io.MultiWriter(os.Stdout, o)
+		if errFile == "" {
+			stderr = io.MultiWriter(os.Stderr, o)
+		}
+	}
+	if errFile != "" {
+		e, err := openTeeFile(errFile, appendMode)
+		if err != nil {
+			closer()
+			return nil, nil, nil, err
+		}
+		files = append(files, e)
+		stderr = io.MultiWriter(os.Stderr, e)
+	}
+	return stdout, stderr, closer, nil
+}
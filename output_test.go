@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestTeeFile(t *testing.T) {
+	tmpdir := t.TempDir()
+	file := path.Join(tmpdir, "out.log")
+	if err := os.WriteFile(file, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		append bool
+		wants  string
+	}{
+		{false, "line1\n"},
+		{true, "line1\nline1\n"},
+	}
+	for _, test := range tests {
+		o, err := openTeeFile(file, test.append)
+		if err != nil {
+			t.Fatalf("openTeeFile: %v", err)
+		}
+		o.Write([]byte("line1\n"))
+		o.Close()
+		if b, _ := os.ReadFile(file); string(b) != test.wants {
+			t.Fatalf("%q (append=%v) = %q wants %q", file, test.append, b, test.wants)
+		}
+	}
+
+	// rotated externally
+	o, err := openTeeFile(file, false)
+	if err != nil {
+		t.Fatalf("openTeeFile: %v", err)
+	}
+	defer o.Close()
+	o.Write([]byte("line1\n"))
+	if err := os.Rename(file, file+".1"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	// not checked until teeCheckInterval passes
+	o.Write([]byte("line2\n"))
+	o.checked = time.Time{}
+	o.Write([]byte("line3\n"))
+
+	if b, _ := os.ReadFile(file + ".1"); string(b) != "line1\nline2\n" {
+		t.Fatalf("%q = %q wants %q", file+".1", b, "line1\nline2\n")
+	}
+	if b, _ := os.ReadFile(file); string(b) != "line3\n" {
+		t.Fatalf("%q = %q wants %q", file, b, "line3\n")
+	}
+}
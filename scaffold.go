@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// project is a kind of the project detected by the files in the directory.
+type project struct {
+	name     string
+	files    []string // files which identify the project
+	patterns []string
+	ignores  []string
+	command  string
+}
+
+// projects is the kinds of the project in the order of the detection.
+var projects = []project{
+	{
+		name:     "Go",
+		files:    []string{"go.mod"},
+		patterns: []string{"**/*.go", "**/go.{mod,sum}"},
+		ignores:  []string{"**/.*", "**/*_test.go"},
+		command:  "go run .",
+	},
+	{
+		name:     "Node.js",
+		files:    []string{"package.json"},
+		patterns: []string{"**/*.{js,mjs,ts,json}"},
+		ignores:  []string{"**/.*", "**/node_modules/**"},
+		command:  "npm start",
+	},
+	{
+		name:     "Python",
+		files:    []string{"pyproject.toml", "requirements.txt", "main.py"},
+		patterns: []string{"**/*.py"},
+		ignores:  []string{"**/.*", "**/__pycache__/**", "**/.venv/**"},
+		command:  "python main.py",
+	},
+}
+
+// detectProject returns the kind of the project in the directory, or nil if unknown.
+func detectProject(dir string) *project {
+	for i, p := range projects {
+		for _, f := range p.files {
+			if _, err := os.Stat(filepath.Join(dir, f)); err == nil {
+				return &projects[i]
+			}
+		}
+	}
+	return nil
+}
+
+// scaffoldConfig writes the starter config with the commented examples for the project in the directory.
+func scaffoldConfig(w io.Writer, dir string) {
+	quote := func(ss []string) string {
+		q := make([]string, len(ss))
+		for i, s := range ss {
+			q[i] = "'" + s + "'"
+		}
+		return "[" + strings.Join(q, ", ") + "]"
+	}
+
+	p := detectProject(dir)
+	if p != nil {
+		fmt.Fprintf(w, "# arelo config for the %s project, written by \"arelo init\".\n", p.name)
+	} else {
+		fmt.Fprintf(w, "# arelo config, written by \"arelo init\".\n")
+	}
+	fmt.Fprintf(w, "# The options on the command line and the environment variables take precedence over this file.\n\n")
+	fmt.Fprintf(w, "# paths to watch (default: the current directory)\n")
+	fmt.Fprintf(w, "# targets: [./src, ./lib]\n\n")
+	fmt.Fprintf(w, "# glob patterns of the files to trigger the restart\n")
+	if p != nil {
+		fmt.Fprintf(w, "patterns: %s\n\n", quote(p.patterns))
+		fmt.Fprintf(w, "# glob patterns of the files to ignore\n")
+		fmt.Fprintf(w, "ignores: %s\n\n", quote(p.ignores))
+	} else {
+		fmt.Fprintf(w, "# patterns: ['**/*.go']\n\n")
+		fmt.Fprintf(w, "# glob patterns of the files to ignore\n")
+		fmt.Fprintf(w, "ignores: ['**/.*']\n\n")
+	}
+	fmt.Fprintf(w, "# duration to wait before the restart\n")
+	fmt.Fprintf(w, "# delay: 1s\n\n")
+	fmt.Fprintf(w, "# signal to stop the command\n")
+	fmt.Fprintf(w, "# signal: SIGTERM\n\n")
+	fmt.Fprintf(w, "# restart the command when it exits\n")
+	fmt.Fprintf(w, "# restart: false\n\n")
+	fmt.Fprintf(w, "# command to run, or the list of the arguments (e.g. [sh, -c, \"make && ./app\"])\n")
+	if p != nil {
+		fmt.Fprintf(w, "command: %s\n", p.command)
+	} else {
+		fmt.Fprintf(w, "# command: make run\n")
+	}
+}
+
+// initConfig writes the starter config file to the directory.
+// It refuses to overwrite the existing file without --force.
+func initConfig(w io.Writer, dir string, args []string) error {
+	force := false
+	for _, a := range args {
+		switch a {
+		case "-f", "--force":
+			force = true
+		default:
+			return xerrors.New("usage: arelo init [--force]")
+		}
+	}
+
+	var b strings.Builder
+	scaffoldConfig(&b, dir)
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file := filepath.Join(dir, defaultConfigFile)
+	f, err := os.OpenFile(file, flag, 0o644)
+	if xerrors.Is(err, os.ErrExist) {
+		return xerrors.Errorf("%s already exists (give --force to overwrite)", defaultConfigFile)
+	}
+	if err != nil {
+		return xerrors.Errorf("init: %w", err)
+	}
+	if _, err := io.WriteString(f, b.String()); err != nil {
+		f.Close()
+		return xerrors.Errorf("init: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return xerrors.Errorf("init: %w", err)
+	}
+	fmt.Fprintf(w, "wrote %s\n", defaultConfigFile)
+	return nil
+}
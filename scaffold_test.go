@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInitConfig(t *testing.T) {
+	tests := map[string]struct {
+		file     string
+		patterns []string
+		command  []string
+	}{
+		"go":      {"go.mod", []string{"**/*.go", "**/go.{mod,sum}"}, []string{"go run ."}},
+		"node":    {"package.json", []string{"**/*.{js,mjs,ts,json}"}, []string{"npm start"}},
+		"python":  {"requirements.txt", []string{"**/*.py"}, []string{"python main.py"}},
+		"unknown": {"", nil, nil},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if test.file != "" {
+				if err := os.WriteFile(filepath.Join(dir, test.file), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := initConfig(&bytes.Buffer{}, dir, nil); err != nil {
+				t.Fatalf("initConfig: %v", err)
+			}
+			cfg, err := readConfigFile(filepath.Join(dir, defaultConfigFile), false)
+			if err != nil {
+				t.Fatalf("readConfigFile: %v", err)
+			}
+			if p := cfg["patterns"].items; !reflect.DeepEqual(p, test.patterns) {
+				t.Fatalf("patterns = %q, wants %q", p, test.patterns)
+			}
+			if c := cfg["command"].items; !reflect.DeepEqual(c, test.command) {
+				t.Fatalf("command = %q, wants %q", c, test.command)
+			}
+			if _, ok := cfg["ignores"]; !ok {
+				t.Fatalf("ignores must be written")
+			}
+		})
+	}
+}
+
+func TestInitConfigExists(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, defaultConfigFile)
+	if err := os.WriteFile(file, []byte("delay: 2s\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := initConfig(&bytes.Buffer{}, dir, nil); err == nil {
+		t.Fatalf("initConfig must be error when the config exists")
+	}
+	if b, _ := os.ReadFile(file); string(b) != "delay: 2s\n" {
+		t.Fatalf("config is overwritten: %q", b)
+	}
+
+	if err := initConfig(&bytes.Buffer{}, dir, []string{"--force"}); err != nil {
+		t.Fatalf("initConfig --force: %v", err)
+	}
+	if b, _ := os.ReadFile(file); string(b) == "delay: 2s\n" {
+		t.Fatalf("config is not overwritten with --force")
+	}
+
+	if err := initConfig(&bytes.Buffer{}, dir, []string{"--foo"}); err == nil {
+		t.Fatalf("initConfig must be error for the unknown option")
+	}
+}
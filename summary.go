@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// session is the statistics of the session printed by --summary-on-exit,
+// and the current state printed by --status-signal.
+var session = sessionStats{triggers: make(map[string]int)}
+
+type sessionStats struct {
+	mu       sync.Mutex
+	starts   int
+	crashes  int
+	triggers map[string]int // number of the triggers by each file
+
+	state       string // what the runner is doing
+	stateSince  time.Time
+	running     bool
+	exitStatus  string
+	exitedAt    time.Time
+	paused      int // crashes in a row when the auto restart is paused by --max-restarts
+	lastTrigger string
+	triggeredAt time.Time
+	watches     int // number of the watched directories
+}
+
+func (s *sessionStats) started() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.starts++
+	s.running = true
+}
+
+func (s *sessionStats) exited(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.exitStatus = status
+	s.exitedAt = time.Now()
+}
+
+func (s *sessionStats) setState(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	s.stateSince = time.Now()
+}
+
+func (s *sessionStats) setPaused(crashes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = crashes
+}
+
+func (s *sessionStats) watching(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watches = n
+}
+
+func (s *sessionStats) crashed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.crashes++
+}
+
+func (s *sessionStats) triggered(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.triggers[name]++
+	s.lastTrigger = name
+	s.triggeredAt = time.Now()
+}
+
+// mostTriggered returns the file which triggered the most times.
+func (s *sessionStats) mostTriggered() (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var name string
+	var n int
+	for f, c := range s.triggers {
+		if c > n || (c == n && f < name) {
+			name, n = f, c
+		}
+	}
+	return name, n
+}
+
+func (s *sessionStats) print(runtime time.Duration) {
+	name, n := s.mostTriggered()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	restarts := s.starts - 1
+	if restarts < 0 {
+		restarts = 0
+	}
+	total := 0
+	for _, c := range s.triggers {
+		total += c
+	}
+	log.Printf("[ARELO] summary: runtime %v, %d restarts, %d crashes, %d triggers",
+		runtime.Round(time.Second), restarts, s.crashes, total)
+	if n > 0 {
+		log.Printf("[ARELO] summary: most triggered by %q (%d times)", name, n)
+	}
+}
+
+// printStatus prints the current state for the diagnosis of "why no restart" (--status-signal).
+func (s *sessionStats) printStatus(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ago := func(t time.Time) time.Duration { return now.Sub(t).Round(time.Millisecond) }
+	if s.state != "" {
+		log.Printf("[ARELO] status: %s (for %v)", s.state, ago(s.stateSince))
+	}
+	if s.running {
+		log.Printf("[ARELO] status: command is running")
+	} else if !s.exitedAt.IsZero() {
+		log.Printf("[ARELO] status: command is not running, %s %v ago", s.exitStatus, ago(s.exitedAt))
+	} else {
+		log.Printf("[ARELO] status: command is not started")
+	}
+	if s.paused > 0 {
+		log.Printf("[ARELO] status: auto restart is paused after %d crashes in a row", s.paused)
+	}
+	log.Printf("[ARELO] status: %d starts, %d crashes", s.starts, s.crashes)
+	if s.lastTrigger != "" {
+		log.Printf("[ARELO] status: last trigger %q %v ago", s.lastTrigger, ago(s.triggeredAt))
+	} else {
+		log.Printf("[ARELO] status: no trigger yet")
+	}
+	log.Printf("[ARELO] status: watching %d directories", s.watches)
+}
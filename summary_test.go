@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionStats(t *testing.T) {
+	s := sessionStats{triggers: make(map[string]int)}
+	if name, n := s.mostTriggered(); name != "" || n != 0 {
+		t.Fatalf("mostTriggered() = %q, %d, wants \"\", 0", name, n)
+	}
+
+	for _, f := range []string{"b.go", "a.go", "b.go", "a.go", "c.go"} {
+		s.triggered(f)
+	}
+	if name, n := s.mostTriggered(); name != "a.go" || n != 2 {
+		t.Fatalf("mostTriggered() = %q, %d, wants \"a.go\", 2", name, n)
+	}
+	s.triggered("b.go")
+	if name, n := s.mostTriggered(); name != "b.go" || n != 3 {
+		t.Fatalf("mostTriggered() = %q, %d, wants \"b.go\", 3", name, n)
+	}
+}
+
+func TestSessionStatus(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s := sessionStats{triggers: make(map[string]int)}
+	s.printStatus(time.Now())
+	for _, w := range []string{"command is not started", "no trigger yet", "watching 0 directories"} {
+		if !strings.Contains(buf.String(), w) {
+			t.Fatalf("status must contain %q:\n%s", w, buf.String())
+		}
+	}
+
+	buf.Reset()
+	s.watching(3)
+	s.started()
+	s.triggered("a.go")
+	s.exited("exit status 1")
+	s.setPaused(5)
+	s.setState("waiting for a trigger")
+	s.printStatus(time.Now())
+	for _, w := range []string{
+		"waiting for a trigger",
+		"command is not running, exit status 1",
+		"paused after 5 crashes in a row",
+		"1 starts, 0 crashes",
+		`last trigger "a.go"`,
+		"watching 3 directories",
+	} {
+		if !strings.Contains(buf.String(), w) {
+			t.Fatalf("status must contain %q:\n%s", w, buf.String())
+		}
+	}
+}